@@ -19,6 +19,7 @@ Available Commands:
 	up        Start the Hermyx reverse proxy
 	down 			Close the Hermyx reverse proxy
 	init 			Scaffold hermyx config yaml.
+	cache 			Manage the cache of a Hermyx config
   	help      		Show help for a command
   	version      		Display hermyx version
 
@@ -49,6 +50,55 @@ Options:
   --config   Path to Hermyx config YAML file (default: ./hermyx.config.yaml)`)
 }
 
+func printCacheHelp() {
+	fmt.Println(`Usage:
+  hermyx cache <subcommand> [options]
+
+Subcommands:
+  warm      Pre-populate the cache from a list of URLs
+  export    Write every live cache entry to a snapshot file
+  import    Load a snapshot file into the cache
+
+Run 'hermyx help cache <subcommand>' for details on a subcommand.`)
+}
+
+func printCacheWarmHelp() {
+	fmt.Println(`Usage:
+  hermyx cache warm --file <path> [--config <path>] [--via <url>] [--concurrency <n>] [--rate <n>]
+
+Each line of the file is a URL, or a method and a URL, optionally followed
+by headers separated by ";":
+  /products/42
+  GET https://shop.example.com/products?page=2 Accept-Language: de; X-Device: mobile
+
+Options:
+  --config        Path to Hermyx config YAML file (default: ./hermyx.config.yaml)
+  --file          File listing the requests to warm
+  --via           Send the requests through a running instance at this URL
+                  (e.g. http://127.0.0.1:8080) instead of fetching them directly
+  --concurrency   Number of requests in flight (default: 8)
+  --rate          Maximum requests per second; 0 means unlimited (default: 0)`)
+}
+
+func printCacheSnapshotHelp(subcommand string) {
+	fileFlag, fileHelp := "--out", "Snapshot file to write"
+	if subcommand == "import" {
+		fileFlag, fileHelp = "--in", "Snapshot file to load"
+	}
+
+	fmt.Printf(`Usage:
+  hermyx cache %[1]s %[2]s <path> [--config <path>] [--admin <url>] [--token <token>]
+
+Options:
+  --config   Path to Hermyx config YAML file (default: ./hermyx.config.yaml)
+  %-8[2]s   %[3]s
+  --admin    Admin API of a running instance (e.g. http://127.0.0.1:9090);
+             required for memory caches. Without it the config's backends
+             are opened directly.
+  --token    Admin API token (default: $HERMYX_ADMIN_TOKEN)
+`, subcommand, fileFlag, fileHelp)
+}
+
 func printVersionHelp() {
 	fmt.Println(`Usage:
   hermyx version`)
@@ -141,6 +191,112 @@ func main() {
 			os.Exit(1)
 		}
 
+	case "cache":
+		if len(os.Args) < 3 {
+			printCacheHelp()
+			os.Exit(1)
+		}
+
+		switch os.Args[2] {
+		case "warm":
+			runCmd := flag.NewFlagSet("warm", flag.ExitOnError)
+			configPath := runCmd.String("config", "hermyx.config.yaml", "Path to configuration YAML file")
+			file := runCmd.String("file", "", "File listing the requests to warm")
+			via := runCmd.String("via", "", "Base URL of a running instance to send the requests through")
+			concurrency := runCmd.Int("concurrency", 8, "Number of requests in flight")
+			rate := runCmd.Float64("rate", 0, "Maximum requests per second")
+
+			if err := runCmd.Parse(os.Args[3:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to parse flags: %v\n", err)
+				os.Exit(1)
+			}
+
+			if *file == "" {
+				printCacheWarmHelp()
+				os.Exit(1)
+			}
+
+			absPath, err := filepath.Abs(*configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to resolve config path: %v\n", err)
+				os.Exit(1)
+			}
+
+			if _, err := os.Stat(absPath); *via == "" && os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Config file not found: %s\n", absPath)
+				os.Exit(1)
+			}
+
+			report, err := engine.WarmCache(absPath, engine.WarmOptions{
+				File:        *file,
+				Via:         *via,
+				Concurrency: *concurrency,
+				Rate:        *rate,
+				Progress:    os.Stderr,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to warm the cache: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Warmed %d of %d requests (%d failed, %d skipped)\n", report.Succeeded, report.Total, report.Failed, report.Skipped)
+
+		case "export", "import":
+			subcommand := os.Args[2]
+			fileFlag := "out"
+			if subcommand == "import" {
+				fileFlag = "in"
+			}
+
+			runCmd := flag.NewFlagSet(subcommand, flag.ExitOnError)
+			configPath := runCmd.String("config", "hermyx.config.yaml", "Path to configuration YAML file")
+			file := runCmd.String(fileFlag, "", "Snapshot file")
+			admin := runCmd.String("admin", "", "Admin API of a running instance")
+			token := runCmd.String("token", "", "Admin API token")
+
+			if err := runCmd.Parse(os.Args[3:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to parse flags: %v\n", err)
+				os.Exit(1)
+			}
+
+			if *file == "" {
+				printCacheSnapshotHelp(subcommand)
+				os.Exit(1)
+			}
+
+			absPath, err := filepath.Abs(*configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to resolve config path: %v\n", err)
+				os.Exit(1)
+			}
+
+			if _, err := os.Stat(absPath); *admin == "" && os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Config file not found: %s\n", absPath)
+				os.Exit(1)
+			}
+
+			options := engine.SnapshotOptions{File: *file, Admin: *admin, Token: *token}
+			if subcommand == "export" {
+				count, err := engine.ExportCache(absPath, options)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to export the cache: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("Exported %d entries to %s\n", count, *file)
+			} else {
+				count, err := engine.ImportCache(absPath, options)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to import the cache after %d entries: %v\n", count, err)
+					os.Exit(1)
+				}
+				fmt.Printf("Imported %d entries from %s\n", count, *file)
+			}
+
+		default:
+			fmt.Printf("Unknown cache subcommand: %s\n\n", os.Args[2])
+			printCacheHelp()
+			os.Exit(1)
+		}
+
 	case "version":
 		printVersion()
 
@@ -155,6 +311,15 @@ func main() {
 				printDownHelp()
 			case "init":
 				printInitHelp()
+			case "cache":
+				switch {
+				case len(os.Args) > 3 && os.Args[3] == "warm":
+					printCacheWarmHelp()
+				case len(os.Args) > 3 && (os.Args[3] == "export" || os.Args[3] == "import"):
+					printCacheSnapshotHelp(os.Args[3])
+				default:
+					printCacheHelp()
+				}
 			case "version":
 				printVersionHelp()
 			default:
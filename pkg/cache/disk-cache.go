@@ -2,10 +2,27 @@ package cache
 
 import (
 	"container/list"
+	"hermyx/pkg/utils/regex"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
+// tombstoneExpiry marks a record that deletes its key. It lies in the past,
+// so loadIndices drops the key like any other expired record.
+const tombstoneExpiry = 1
+
+// Tag records share the file with entries. Their key is tagRecordPrefix,
+// the tag, a NUL and the tagged key; their value is empty. They are read
+// back into the tag index instead of the entry index.
+const tagRecordPrefix = "\x00tag\x00"
+
+// Meta records share the file too. Their key is metaRecordPrefix and the
+// name; the last record for a name holds its value. They never enter the
+// entry index, so eviction, Purge and Enumerate do not see them.
+const metaRecordPrefix = "\x00meta\x00"
+
 type DiskCacheEntry struct {
 	offset uint64
 	elem   *list.Element
@@ -19,4 +36,222 @@ type DiskCache struct {
 	index       map[string]*DiskCacheEntry
 	lru         *list.List
 	writeOffset uint64
+	tags        map[string]map[string]struct{}
+	keyTags     map[string][]string
+	meta        map[string]uint64
+}
+
+func (cache *DiskCache) Get(key string) ([]byte, bool, error) {
+	value, _, ok, err := cache.GetWithTtl(key)
+	return value, ok, err
+}
+
+// Delete removes the key and appends a tombstone so that it stays deleted
+// when the index is rebuilt from the file.
+func (cache *DiskCache) Delete(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, found := cache.index[key]; !found {
+		return
+	}
+	cache.delete(key)
+	cache.appendRecord(key, nil, tombstoneExpiry)
+}
+
+// Purge deletes every key matching the glob pattern.
+func (cache *DiskCache) Purge(pattern string) (int, error) {
+	matcher, err := regex.FromGlob(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	purged := 0
+	for key := range cache.index {
+		if !matcher.MatchString(key) {
+			continue
+		}
+		cache.delete(key)
+		if _, err := cache.appendRecord(key, nil, tombstoneExpiry); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Enumerate calls fn with every live entry and its remaining TTL, where 0
+// means the entry never expires. The cache is locked until it returns.
+func (cache *DiskCache) Enumerate(fn func(key string, value []byte, ttl time.Duration) error) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := uint64(time.Now().UnixNano())
+	for elem := cache.lru.Front(); elem != nil; elem = elem.Next() {
+		key, value, expiry, err := cache.readRecord(cache.index[elem.Value.(string)].offset)
+		if err != nil {
+			return err
+		}
+
+		var ttl time.Duration
+		if expiry != 0 {
+			if now > expiry {
+				continue
+			}
+			ttl = time.Duration(expiry - now)
+		}
+		if err := fn(key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMeta appends a meta record that never expires.
+func (cache *DiskCache) SetMeta(name string, value []byte) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	offset, err := cache.appendRecord(metaRecordPrefix+name, value, 0)
+	if err != nil {
+		return err
+	}
+	cache.meta[name] = offset
+	return nil
+}
+
+func (cache *DiskCache) GetMeta(name string) ([]byte, bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	offset, found := cache.meta[name]
+	if !found {
+		return nil, false, nil
+	}
+	_, value, _, err := cache.readRecord(offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func tagRecordKey(tag, key string) string {
+	return tagRecordPrefix + tag + "\x00" + key
+}
+
+// Tag appends a tag record for every tag the key does not carry yet. Each
+// record expires with the entry it points at.
+func (cache *DiskCache) Tag(key string, tags []string, ttl time.Duration) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, found := cache.index[key]; !found {
+		return nil
+	}
+
+	expiry := uint64(0)
+	if ttl > 0 {
+		expiry = uint64(time.Now().Add(ttl).UnixNano())
+	}
+
+	for _, tag := range tags {
+		if _, tagged := cache.tags[tag][key]; tagged {
+			continue
+		}
+		if _, err := cache.appendRecord(tagRecordKey(tag, key), nil, expiry); err != nil {
+			return err
+		}
+		cache.addTag(tag, key)
+	}
+	return nil
+}
+
+// Untag forgets the tag, appending tombstones for its records, and returns
+// the keys that carried it.
+func (cache *DiskCache) Untag(tag string) ([]string, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	keys := make([]string, 0, len(cache.tags[tag]))
+	for key := range cache.tags[tag] {
+		if _, err := cache.appendRecord(tagRecordKey(tag, key), nil, tombstoneExpiry); err != nil {
+			return keys, err
+		}
+		cache.removeTag(tag, key)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (cache *DiskCache) addTag(tag, key string) {
+	keys, ok := cache.tags[tag]
+	if !ok {
+		keys = make(map[string]struct{})
+		cache.tags[tag] = keys
+	}
+	keys[key] = struct{}{}
+	cache.keyTags[key] = append(cache.keyTags[key], tag)
+}
+
+func (cache *DiskCache) removeTag(tag, key string) {
+	if keys, ok := cache.tags[tag]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(cache.tags, tag)
+		}
+	}
+
+	remaining := cache.keyTags[key][:0]
+	for _, t := range cache.keyTags[key] {
+		if t != tag {
+			remaining = append(remaining, t)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(cache.keyTags, key)
+	} else {
+		cache.keyTags[key] = remaining
+	}
+}
+
+// untagKey drops a removed entry from the tag index. Its tag records stay
+// in the file; loadIndices discards those whose entry is gone.
+func (cache *DiskCache) untagKey(key string) {
+	for _, tag := range cache.keyTags[key] {
+		if keys, ok := cache.tags[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(cache.tags, tag)
+			}
+		}
+	}
+	delete(cache.keyTags, key)
+}
+
+// loadTagRecord applies a tag record read back by loadIndices.
+func (cache *DiskCache) loadTagRecord(recordKey string, expired bool) {
+	tag, key, ok := strings.Cut(strings.TrimPrefix(recordKey, tagRecordPrefix), "\x00")
+	if !ok {
+		return
+	}
+	if expired {
+		cache.removeTag(tag, key)
+		return
+	}
+	if _, tagged := cache.tags[tag][key]; !tagged {
+		cache.addTag(tag, key)
+	}
+}
+
+// pruneTags drops tag records that point at entries the index no longer
+// holds, once loadIndices has read the whole file.
+func (cache *DiskCache) pruneTags() {
+	for key := range cache.keyTags {
+		if _, found := cache.index[key]; !found {
+			cache.untagKey(key)
+		}
+	}
 }
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheTagsSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, []byte(key), time.Minute)
+	}
+	cache.Tag("a", []string{"red", "blue"}, time.Minute)
+	cache.Tag("b", []string{"red"}, time.Minute)
+	cache.Tag("c", []string{"green"}, time.Minute)
+	cache.Delete("b")
+	cache.Untag("green")
+	cache.Close()
+
+	cache, err = NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{tag: "red", want: []string{"a"}},
+		{tag: "blue", want: []string{"a"}},
+		{tag: "green", want: []string{}},
+		{tag: "red", want: []string{}},
+	}
+	for _, tt := range tests {
+		keys, err := cache.Untag(tt.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, tt.want) {
+			t.Errorf("Untag(%s) = %v, want %v", tt.tag, keys, tt.want)
+		}
+	}
+}
+
+func TestDiskCacheDeletesSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("kept", []byte("1"), time.Minute)
+	cache.Set("deleted", []byte("2"), time.Minute)
+	cache.Set("purged|1", []byte("3"), time.Minute)
+	cache.Delete("deleted")
+	if purged, err := cache.Purge("purged|*"); err != nil || purged != 1 {
+		t.Fatalf("Purge = %d, %v", purged, err)
+	}
+	cache.Close()
+
+	cache, err = NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	var keys []string
+	cache.Enumerate(func(key string, value []byte, ttl time.Duration) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if !slices.Equal(keys, []string{"kept"}) {
+		t.Errorf("reopened cache holds %v, want only kept", keys)
+	}
+}
+
+func TestDiskCacheMissIsNotAnError(t *testing.T) {
+	cache, err := NewDiskCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("expired", []byte("1"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	for _, key := range []string{"missing", "expired"} {
+		if value, ok, err := cache.Get(key); ok || err != nil || value != nil {
+			t.Errorf("Get(%s) = %q, %v, %v, want a plain miss", key, value, ok, err)
+		}
+	}
+}
+
+func TestDiskCacheMetaIsNotAnEntry(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetMeta("generation|api", []byte("1"))
+	cache.SetMeta("generation|api", []byte("2"))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, []byte(key), time.Minute)
+	}
+	cache.Purge("*")
+	cache.Close()
+
+	cache, err = NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if value, ok, err := cache.GetMeta("generation|api"); !ok || err != nil || string(value) != "2" {
+		t.Errorf("GetMeta = %q, %v, %v, want the last value", value, ok, err)
+	}
+	if _, ok, err := cache.GetMeta("generation|other"); ok || err != nil {
+		t.Errorf("GetMeta of an unset name = %v, %v, want a plain miss", ok, err)
+	}
+	cache.Enumerate(func(key string, value []byte, ttl time.Duration) error {
+		t.Errorf("Enumerate returned %q", key)
+		return nil
+	})
+}
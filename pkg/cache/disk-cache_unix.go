@@ -6,9 +6,9 @@ import (
 	"container/list"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -51,6 +51,9 @@ func NewDiskCache(storagePath string, capacity uint64) (*DiskCache, error) {
 		capacity:    capacity,
 		index:       make(map[string]*DiskCacheEntry),
 		lru:         list.New(),
+		tags:        make(map[string]map[string]struct{}),
+		keyTags:     make(map[string][]string),
+		meta:        make(map[string]uint64),
 		writeOffset: 0,
 	}
 
@@ -96,67 +99,99 @@ func (cache *DiskCache) loadIndices() error {
 			break
 		}
 
-		if expiry != 0 && uint64(time.Now().UnixNano()) > expiry {
-			offset += valLen
+		entryOffset := offset - 4 - 8 - keyLen - 4
+		offset += valLen
+		cache.writeOffset = offset
+
+		expired := expiry != 0 && uint64(time.Now().UnixNano()) > expiry
+		if strings.HasPrefix(key, tagRecordPrefix) {
+			cache.loadTagRecord(key, expired)
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, metaRecordPrefix); ok {
+			cache.meta[name] = entryOffset
+			continue
+		}
+
+		// A later record for the same key supersedes the earlier one, and
+		// an expired record (which includes tombstones) removes the key.
+		cache.delete(key)
+		if expired {
 			continue
 		}
 
-		entryOffset := offset - valLen - 4 - 8 - keyLen - 4
 		elem := cache.lru.PushFront(key)
 		cache.index[key] = &DiskCacheEntry{offset: entryOffset, elem: elem}
-		offset += valLen
-		cache.writeOffset = offset
 	}
 
+	cache.pruneTags()
 	return nil
 }
 
-func (cache *DiskCache) Get(key string) ([]byte, bool, error) {
+// GetWithTtl returns the entry under key with its remaining TTL, where 0
+// means it never expires.
+func (cache *DiskCache) GetWithTtl(key string) ([]byte, time.Duration, bool, error) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
 	entry, found := cache.index[key]
 	if !found {
-		return nil, false, errors.New(fmt.Sprintf("key %s not found", key))
+		return nil, 0, false, nil
+	}
+
+	storedKey, val, expiry, err := cache.readRecord(entry.offset)
+	if err != nil {
+		return nil, 0, false, err
 	}
 
-	offset := entry.offset
+	if storedKey != key {
+		return nil, 0, false, errors.New("key mismatch")
+	}
+
+	now := uint64(time.Now().UnixNano())
+	if expiry != 0 && now > expiry {
+		cache.delete(key)
+		return nil, 0, false, nil
+	}
+
+	cache.lru.MoveToFront(entry.elem)
+
+	var ttl time.Duration
+	if expiry != 0 {
+		ttl = time.Duration(expiry - now)
+	}
+	return val, ttl, true, nil
+}
+
+// readRecord decodes the record starting at offset.
+func (cache *DiskCache) readRecord(offset uint64) (string, []byte, uint64, error) {
 	data := cache.data
 	dataLen := uint64(len(data))
 
 	if offset+4 > dataLen {
-		return nil, false, errors.New("corrupted data")
+		return "", nil, 0, errors.New("corrupted data")
 	}
 	keyLen := uint64(binary.BigEndian.Uint32(data[offset : offset+4]))
 	offset += 4
-	if offset+keyLen > dataLen {
-		return nil, false, errors.New("corrupted data")
-	}
-	storedKey := string(data[offset : offset+keyLen])
-	offset += uint64(keyLen)
-
-	if storedKey != key {
-		return nil, false, errors.New("key mismatch")
+	if offset+keyLen+8+4 > dataLen {
+		return "", nil, 0, errors.New("corrupted data")
 	}
+	key := string(data[offset : offset+keyLen])
+	offset += keyLen
 
-	expiry := uint64(binary.BigEndian.Uint64(data[offset : offset+8]))
+	expiry := binary.BigEndian.Uint64(data[offset : offset+8])
 	offset += 8
-	if expiry != 0 && uint64(time.Now().UnixNano()) > expiry {
-		cache.delete(key)
-		return nil, false, errors.New("key expired")
-	}
 
 	valLen := uint64(binary.BigEndian.Uint32(data[offset : offset+4]))
 	offset += 4
 	if offset+valLen > dataLen {
-		return nil, false, errors.New("corrupted data")
+		return "", nil, 0, errors.New("corrupted data")
 	}
 
 	val := make([]byte, valLen)
 	copy(val, data[offset:offset+valLen])
 
-	cache.lru.MoveToFront(entry.elem)
-	return val, true, nil
+	return key, val, expiry, nil
 }
 
 func (cache *DiskCache) Set(key string, value []byte, ttl time.Duration) error {
@@ -168,51 +203,60 @@ func (cache *DiskCache) Set(key string, value []byte, ttl time.Duration) error {
 		_ = existing
 	}
 
-	keyLen := uint32(len(key))
-	valLen := uint32(len(value))
 	expiry := uint64(0)
 	if ttl > 0 {
 		expiry = uint64(time.Now().Add(ttl).UnixNano())
 	}
 
+	offset, err := cache.appendRecord(key, value, expiry)
+	if err != nil {
+		return err
+	}
+
+	elem := cache.lru.PushFront(key)
+	cache.index[key] = &DiskCacheEntry{offset: offset, elem: elem}
+
+	if uint64(cache.lru.Len()) > cache.capacity {
+		tail := cache.lru.Back()
+		if tail != nil {
+			cache.delete(tail.Value.(string))
+		}
+	}
+
+	return nil
+}
+
+// appendRecord writes a record at the end of the mapped region, growing the
+// file when needed, and returns its offset.
+func (cache *DiskCache) appendRecord(key string, value []byte, expiry uint64) (uint64, error) {
 	entrySize := 4 + len(key) + 8 + 4 + len(value)
 	requiredSize := cache.writeOffset + uint64(entrySize)
 
 	if requiredSize > uint64(len(cache.data)) {
 		if err := cache.expandFile(requiredSize); err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	offset := cache.writeOffset
+	start := cache.writeOffset
+	offset := start
 	data := cache.data
 
-	binary.BigEndian.PutUint32(data[offset:offset+4], keyLen)
+	binary.BigEndian.PutUint32(data[offset:offset+4], uint32(len(key)))
 	offset += 4
-	copy(data[offset:offset+uint64(keyLen)], key)
-	offset += uint64(keyLen)
+	copy(data[offset:offset+uint64(len(key))], key)
+	offset += uint64(len(key))
 
 	binary.BigEndian.PutUint64(data[offset:offset+8], expiry)
 	offset += 8
 
-	binary.BigEndian.PutUint32(data[offset:offset+4], valLen)
+	binary.BigEndian.PutUint32(data[offset:offset+4], uint32(len(value)))
 	offset += 4
-	copy(data[offset:offset+uint64(valLen)], value)
-	offset += uint64(valLen)
-
-	elem := cache.lru.PushFront(key)
-	cache.index[key] = &DiskCacheEntry{offset: cache.writeOffset, elem: elem}
+	copy(data[offset:offset+uint64(len(value))], value)
+	offset += uint64(len(value))
 
 	cache.writeOffset = offset
-
-	if uint64(cache.lru.Len()) > cache.capacity {
-		tail := cache.lru.Back()
-		if tail != nil {
-			cache.delete(tail.Value.(string))
-		}
-	}
-
-	return nil
+	return start, nil
 }
 
 func (cache *DiskCache) delete(key string) {
@@ -222,12 +266,7 @@ func (cache *DiskCache) delete(key string) {
 	}
 	cache.lru.Remove(entry.elem)
 	delete(cache.index, key)
-}
-
-func (cache *DiskCache) Delete(key string) {
-	cache.mu.Lock()
-	defer cache.mu.Unlock()
-	cache.delete(key)
+	cache.untagKey(key)
 }
 
 func (cache *DiskCache) expandFile(newSize uint64) error {
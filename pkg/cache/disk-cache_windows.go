@@ -6,9 +6,9 @@ import (
 	"container/list"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -30,6 +30,9 @@ func NewDiskCache(storagePath string, capacity uint64) (*DiskCache, error) {
 		capacity:    capacity,
 		index:       make(map[string]*DiskCacheEntry),
 		lru:         list.New(),
+		tags:        make(map[string]map[string]struct{}),
+		keyTags:     make(map[string][]string),
+		meta:        make(map[string]uint64),
 		writeOffset: uint64(stat.Size()),
 	}
 
@@ -94,74 +97,104 @@ func (cache *DiskCache) loadIndices() error {
 			break
 		}
 
-		if expiry != 0 && uint64(time.Now().UnixNano()) > expiry {
-			offset += uint64(valLen)
+		entryOffset := offset - 4 - 8 - uint64(keyLen) - 4
+		offset += uint64(valLen)
+		cache.writeOffset = offset
+
+		expired := expiry != 0 && uint64(time.Now().UnixNano()) > expiry
+		if strings.HasPrefix(key, tagRecordPrefix) {
+			cache.loadTagRecord(key, expired)
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, metaRecordPrefix); ok {
+			cache.meta[name] = entryOffset
+			continue
+		}
+
+		// A later record for the same key supersedes the earlier one, and
+		// an expired record (which includes tombstones) removes the key.
+		cache.delete(key)
+		if expired {
 			continue
 		}
 
-		entryOffset := offset - 4 - 8 - uint64(keyLen) - 4
 		elem := cache.lru.PushFront(key)
 		cache.index[key] = &DiskCacheEntry{offset: entryOffset, elem: elem}
-		offset += uint64(valLen)
-		cache.writeOffset = offset
 	}
 
+	cache.pruneTags()
 	return nil
 }
 
-func (cache *DiskCache) Get(key string) ([]byte, bool, error) {
+// GetWithTtl returns the entry under key with its remaining TTL, where 0
+// means it never expires.
+func (cache *DiskCache) GetWithTtl(key string) ([]byte, time.Duration, bool, error) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
 	entry, found := cache.index[key]
 	if !found {
-		return nil, false, errors.New(fmt.Sprintf("key %s not found", key))
+		return nil, 0, false, nil
+	}
+
+	storedKey, val, expiry, err := cache.readRecord(entry.offset)
+	if err != nil {
+		return nil, 0, false, err
 	}
 
-	offset := entry.offset
+	if storedKey != key {
+		return nil, 0, false, errors.New("key mismatch")
+	}
+
+	now := uint64(time.Now().UnixNano())
+	if expiry != 0 && now > expiry {
+		cache.delete(key)
+		return nil, 0, false, nil
+	}
+
+	cache.lru.MoveToFront(entry.elem)
+
+	var ttl time.Duration
+	if expiry != 0 {
+		ttl = time.Duration(expiry - now)
+	}
+	return val, ttl, true, nil
+}
+
+// readRecord decodes the record starting at offset.
+func (cache *DiskCache) readRecord(offset uint64) (string, []byte, uint64, error) {
 	buf := make([]byte, 8)
 
 	if _, err := cache.file.ReadAt(buf[:4], int64(offset)); err != nil {
-		return nil, false, err
+		return "", nil, 0, err
 	}
 	keyLen := binary.BigEndian.Uint32(buf[:4])
 	offset += 4
 
 	keyBuf := make([]byte, keyLen)
 	if _, err := cache.file.ReadAt(keyBuf, int64(offset)); err != nil {
-		return nil, false, err
+		return "", nil, 0, err
 	}
-	storedKey := string(keyBuf)
 	offset += uint64(keyLen)
 
-	if storedKey != key {
-		return nil, false, errors.New("key mismatch")
-	}
-
 	if _, err := cache.file.ReadAt(buf, int64(offset)); err != nil {
-		return nil, false, err
+		return "", nil, 0, err
 	}
 	expiry := binary.BigEndian.Uint64(buf)
 	offset += 8
 
-	if expiry != 0 && uint64(time.Now().UnixNano()) > expiry {
-		cache.delete(key)
-		return nil, false, errors.New("key expired")
-	}
-
 	if _, err := cache.file.ReadAt(buf[:4], int64(offset)); err != nil {
-		return nil, false, err
+		return "", nil, 0, err
 	}
 	valLen := binary.BigEndian.Uint32(buf[:4])
 	offset += 4
 
 	val := make([]byte, valLen)
 	if _, err := cache.file.ReadAt(val, int64(offset)); err != nil {
-		return nil, false, err
+		return "", nil, 0, err
 	}
 
-	cache.lru.MoveToFront(entry.elem)
-	return val, true, nil
+	return string(keyBuf), val, expiry, nil
 }
 
 func (cache *DiskCache) Set(key string, value []byte, ttl time.Duration) error {
@@ -173,30 +206,18 @@ func (cache *DiskCache) Set(key string, value []byte, ttl time.Duration) error {
 		_ = existing
 	}
 
-	keyLen := uint32(len(key))
-	valLen := uint32(len(value))
 	expiry := uint64(0)
 	if ttl > 0 {
 		expiry = uint64(time.Now().Add(ttl).UnixNano())
 	}
 
-	header := make([]byte, 4+len(key)+8+4)
-	binary.BigEndian.PutUint32(header[0:], keyLen)
-	copy(header[4:], key)
-	binary.BigEndian.PutUint64(header[4+len(key):], expiry)
-	binary.BigEndian.PutUint32(header[4+len(key)+8:], valLen)
-
-	offset := cache.writeOffset
-	if _, err := cache.file.WriteAt(header, int64(offset)); err != nil {
-		return err
-	}
-	if _, err := cache.file.WriteAt(value, int64(offset+uint64(len(header)))); err != nil {
+	offset, err := cache.appendRecord(key, value, expiry)
+	if err != nil {
 		return err
 	}
 
 	elem := cache.lru.PushFront(key)
 	cache.index[key] = &DiskCacheEntry{offset: offset, elem: elem}
-	cache.writeOffset += uint64(len(header)) + uint64(len(value))
 
 	if uint64(cache.lru.Len()) > cache.capacity {
 		if tail := cache.lru.Back(); tail != nil {
@@ -207,6 +228,26 @@ func (cache *DiskCache) Set(key string, value []byte, ttl time.Duration) error {
 	return nil
 }
 
+// appendRecord writes a record at the end of the file and returns its offset.
+func (cache *DiskCache) appendRecord(key string, value []byte, expiry uint64) (uint64, error) {
+	header := make([]byte, 4+len(key)+8+4)
+	binary.BigEndian.PutUint32(header[0:], uint32(len(key)))
+	copy(header[4:], key)
+	binary.BigEndian.PutUint64(header[4+len(key):], expiry)
+	binary.BigEndian.PutUint32(header[4+len(key)+8:], uint32(len(value)))
+
+	offset := cache.writeOffset
+	if _, err := cache.file.WriteAt(header, int64(offset)); err != nil {
+		return 0, err
+	}
+	if _, err := cache.file.WriteAt(value, int64(offset+uint64(len(header)))); err != nil {
+		return 0, err
+	}
+
+	cache.writeOffset += uint64(len(header)) + uint64(len(value))
+	return offset, nil
+}
+
 func (cache *DiskCache) delete(key string) {
 	entry, found := cache.index[key]
 	if !found {
@@ -214,12 +255,7 @@ func (cache *DiskCache) delete(key string) {
 	}
 	cache.lru.Remove(entry.elem)
 	delete(cache.index, key)
-}
-
-func (cache *DiskCache) Delete(key string) {
-	cache.mu.Lock()
-	defer cache.mu.Unlock()
-	cache.delete(key)
+	cache.untagKey(key)
 }
 
 func (cache *DiskCache) Close() error {
@@ -2,32 +2,60 @@ package cache
 
 import (
 	"container/list"
+	"errors"
+	"hermyx/pkg/utils/regex"
+	"slices"
 	"sync"
 	"time"
 )
 
+// entryOverhead approximates the memory an entry costs beyond its key and
+// value: the entry struct, its list element and its share of the map.
+const entryOverhead = 160
+
+var ErrEntryTooLarge = errors.New("entry exceeds the cache byte limit")
+
 type entry struct {
 	key       string
 	value     []byte
 	expiresAt time.Time
 	element   *list.Element
+	tags      []string
+}
+
+func (e *entry) size() uint64 {
+	size := uint64(len(e.key)+len(e.value)) + entryOverhead
+	for _, tag := range e.tags {
+		size += uint64(len(tag))
+	}
+	return size
 }
 
 type Cache struct {
 	capacity uint64
+	maxBytes uint64
+	bytes    uint64
 	mu       sync.Mutex
 	items    map[string]*entry
 	order    *list.List
+	tagged   map[string]map[string]struct{}
+	meta     map[string][]byte
 }
 
-func NewCache(capacity uint64) *Cache {
+// NewCache creates an LRU cache holding at most capacity entries. When
+// maxBytes is non-zero the cache also evicts until the accounted size of
+// its keys, values and per-entry overhead fits within it.
+func NewCache(capacity uint64, maxBytes uint64) *Cache {
 	if capacity <= 0 {
 		panic("capacity must be > 0")
 	}
 	return &Cache{
 		capacity: capacity,
+		maxBytes: maxBytes,
 		items:    make(map[string]*entry),
 		order:    list.New(),
+		tagged:   make(map[string]map[string]struct{}),
+		meta:     make(map[string][]byte),
 	}
 }
 
@@ -35,28 +63,46 @@ func (c *Cache) Set(key string, value []byte, ttl time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.maxBytes > 0 && uint64(len(key)+len(value))+entryOverhead > c.maxBytes {
+		if _, ok := c.items[key]; ok {
+			c.remove(key)
+		}
+		return ErrEntryTooLarge
+	}
+
 	if e, ok := c.items[key]; ok {
+		c.bytes -= e.size()
+		c.untag(e)
 		e.value = value
 		e.expiresAt = time.Now().Add(ttl)
+		c.bytes += e.size()
 		c.order.MoveToFront(e.element)
-		return nil
+	} else {
+		elem := c.order.PushFront(key)
+		e := &entry{
+			key:       key,
+			value:     value,
+			expiresAt: time.Now().Add(ttl),
+			element:   elem,
+		}
+		c.items[key] = e
+		c.bytes += e.size()
 	}
 
-	if len(c.items) >= int(c.capacity) {
+	for c.overLimit() {
 		c.evict()
 	}
 
-	elem := c.order.PushFront(key)
-	c.items[key] = &entry{
-		key:       key,
-		value:     value,
-		expiresAt: time.Now().Add(ttl),
-		element:   elem,
-	}
-
 	return nil
 }
 
+func (c *Cache) overLimit() bool {
+	if uint64(len(c.items)) > c.capacity {
+		return true
+	}
+	return c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
 func (c *Cache) Get(key string) ([]byte, bool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -77,6 +123,71 @@ func (c *Cache) remove(key string) {
 	e := c.items[key]
 	c.order.Remove(e.element)
 	delete(c.items, key)
+	c.bytes -= e.size()
+	c.untag(e)
+}
+
+// untag drops the entry from the tag index. The entry's tags stay counted
+// in its size until the caller has subtracted it.
+func (c *Cache) untag(e *entry) {
+	for _, tag := range e.tags {
+		keys := c.tagged[tag]
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+	e.tags = nil
+}
+
+// Tag records that the entry under key carries each of the tags. The tags
+// live as long as the entry, so ttl is not needed here.
+func (c *Cache) Tag(key string, tags []string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+
+	c.bytes -= e.size()
+	for _, tag := range tags {
+		keys, ok := c.tagged[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tagged[tag] = keys
+		}
+		if _, seen := keys[key]; !seen {
+			keys[key] = struct{}{}
+			e.tags = append(e.tags, tag)
+		}
+	}
+	c.bytes += e.size()
+
+	for c.overLimit() {
+		c.evict()
+	}
+	return nil
+}
+
+// Untag forgets the tag and returns the keys that carried it.
+func (c *Cache) Untag(tag string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.tagged[tag]))
+	for key := range c.tagged[tag] {
+		keys = append(keys, key)
+
+		e := c.items[key]
+		c.bytes -= e.size()
+		e.tags = slices.DeleteFunc(e.tags, func(t string) bool { return t == tag })
+		c.bytes += e.size()
+	}
+	delete(c.tagged, tag)
+
+	return keys, nil
 }
 
 func (c *Cache) evict() {
@@ -96,12 +207,88 @@ func (c *Cache) Delete(key string) {
 	}
 }
 
+// Purge removes every entry whose key matches the glob pattern and returns
+// how many were removed.
+func (c *Cache) Purge(pattern string) (int, error) {
+	matcher, err := regex.FromGlob(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if key := elem.Value.(string); matcher.MatchString(key) {
+			c.remove(key)
+			purged++
+		}
+		elem = next
+	}
+	return purged, nil
+}
+
+// Enumerate calls fn with every live entry and its remaining TTL, from most
+// to least recently used. fn runs without the lock held, on a snapshot
+// taken when Enumerate was called.
+func (c *Cache) Enumerate(fn func(key string, value []byte, ttl time.Duration) error) error {
+	type snapshot struct {
+		key       string
+		value     []byte
+		expiresAt time.Time
+	}
+
+	c.mu.Lock()
+	entries := make([]snapshot, 0, len(c.items))
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		e := c.items[elem.Value.(string)]
+		entries = append(entries, snapshot{e.key, e.value, e.expiresAt})
+	}
+	c.mu.Unlock()
+
+	for _, e := range entries {
+		ttl := time.Until(e.expiresAt)
+		if ttl <= 0 {
+			continue
+		}
+		if err := fn(e.key, e.value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMeta keeps a value beside the entries, where neither eviction nor
+// Purge reach it.
+func (c *Cache) SetMeta(name string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.meta[name] = value
+	return nil
+}
+
+func (c *Cache) GetMeta(name string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.meta[name]
+	return value, ok, nil
+}
+
 func (c *Cache) Len() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return len(c.items)
 }
 
+// Bytes reports the accounted size of everything currently stored.
+func (c *Cache) Bytes() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
 func (c *Cache) Close() error {
 	return nil
 }
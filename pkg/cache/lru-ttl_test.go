@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheAccountsBytes(t *testing.T) {
+	size := func(key, value string) uint64 { return uint64(len(key)+len(value)) + entryOverhead }
+
+	tests := []struct {
+		name string
+		run  func(c *Cache)
+		want uint64
+	}{
+		{name: "empty", run: func(c *Cache) {}},
+		{name: "one entry", run: func(c *Cache) { c.Set("a", []byte("12345"), time.Minute) }, want: size("a", "12345")},
+		{name: "replaced value", run: func(c *Cache) {
+			c.Set("a", []byte("12345"), time.Minute)
+			c.Set("a", []byte("1"), time.Minute)
+		}, want: size("a", "1")},
+		{name: "deleted", run: func(c *Cache) {
+			c.Set("a", []byte("12345"), time.Minute)
+			c.Delete("a")
+		}},
+		{name: "tagged", run: func(c *Cache) {
+			c.Set("a", []byte("1"), time.Minute)
+			c.Tag("a", []string{"tag"}, time.Minute)
+		}, want: size("a", "1") + 3},
+		{name: "untagged", run: func(c *Cache) {
+			c.Set("a", []byte("1"), time.Minute)
+			c.Tag("a", []string{"tag"}, time.Minute)
+			c.Untag("tag")
+		}, want: size("a", "1")},
+		{name: "purged", run: func(c *Cache) {
+			c.Set("a|1", []byte("1"), time.Minute)
+			c.Set("b|1", []byte("1"), time.Minute)
+			c.Purge("a|*")
+		}, want: size("b|1", "1")},
+		{name: "expired on read", run: func(c *Cache) {
+			c.Set("a", []byte("1"), -time.Second)
+			c.Get("a")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(10, 0)
+			tt.run(c)
+			if c.Bytes() != tt.want {
+				t.Errorf("Bytes = %d, want %d", c.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheEvictsToByteLimit(t *testing.T) {
+	value := make([]byte, 100)
+	entrySize := uint64(1+len(value)) + entryOverhead
+	c := NewCache(100, 3*entrySize)
+
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, value, time.Minute)
+	}
+	c.Get("a")
+	c.Set("d", value, time.Minute)
+
+	if _, ok, _ := c.Get("b"); ok {
+		t.Error("least recently used entry b survived")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok, _ := c.Get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+	if c.Bytes() > 3*entrySize {
+		t.Errorf("Bytes = %d over the limit of %d", c.Bytes(), 3*entrySize)
+	}
+}
+
+func TestCacheRejectsEntryTooLarge(t *testing.T) {
+	c := NewCache(10, 200)
+	c.Set("a", []byte("small"), time.Minute)
+
+	if err := c.Set("a", make([]byte, 200), time.Minute); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("Set = %v, want ErrEntryTooLarge", err)
+	}
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("the previous value outlived a rejected replacement")
+	}
+	if c.Len() != 0 || c.Bytes() != 0 {
+		t.Errorf("Len = %d, Bytes = %d after the rejection, want both 0", c.Len(), c.Bytes())
+	}
+}
+
+func TestCacheEvictsToCapacity(t *testing.T) {
+	c := NewCache(2, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, []byte(key), time.Minute)
+	}
+	if _, ok, _ := c.Get("a"); ok || c.Len() != 2 {
+		t.Errorf("Len = %d with a still present = %v, want the oldest entry gone", c.Len(), ok)
+	}
+}
+
+func TestCacheTags(t *testing.T) {
+	c := NewCache(10, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, []byte(key), time.Minute)
+	}
+	c.Tag("a", []string{"red"}, time.Minute)
+	c.Tag("b", []string{"red", "red"}, time.Minute)
+	c.Tag("missing", []string{"red"}, time.Minute)
+	c.Delete("b")
+
+	keys, _ := c.Untag("red")
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Untag(red) = %v, want [a]", keys)
+	}
+	if keys, _ := c.Untag("red"); len(keys) != 0 {
+		t.Errorf("second Untag(red) = %v, want none", keys)
+	}
+}
+
+func TestCacheMetaIsNotAnEntry(t *testing.T) {
+	cache := NewCache(1, 0)
+	cache.SetMeta("generation|api", []byte("3"))
+	cache.Set("a", []byte("a"), time.Minute)
+	cache.Set("b", []byte("b"), time.Minute)
+	cache.Purge("*")
+
+	if value, ok, _ := cache.GetMeta("generation|api"); !ok || string(value) != "3" {
+		t.Errorf("GetMeta = %q, %v, want the value to outlive eviction and Purge", value, ok)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len = %d, want meta values left out", cache.Len())
+	}
+}
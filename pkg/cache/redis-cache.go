@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"hermyx/pkg/models"
+	"hermyx/pkg/utils/regex"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,10 +18,15 @@ type RedisCache struct {
 }
 
 func NewRedisCache(config *models.RedisConfig) *RedisCache {
+	db := 0
+	if config.DB != nil {
+		db = *config.DB
+	}
+
 	client := redis.NewClient(&redis.Options{
 		Addr:     config.Address,
 		Password: config.Password,
-		DB:       *config.DB,
+		DB:       db,
 	})
 
 	return &RedisCache{
@@ -47,10 +54,184 @@ func (r *RedisCache) Get(key string) ([]byte, bool, error) {
 	return val, true, nil
 }
 
+// GetWithTtl returns the entry under key with its remaining TTL, where 0
+// means it never expires, in a single round trip.
+func (r *RedisCache) GetWithTtl(key string) ([]byte, time.Duration, bool, error) {
+	pipe := r.client.Pipeline()
+	value := pipe.Get(r.ctx, r.key(key))
+	ttl := pipe.PTTL(r.ctx, r.key(key))
+	if _, err := pipe.Exec(r.ctx); err == redis.Nil {
+		return nil, 0, false, nil
+	} else if err != nil {
+		return nil, 0, false, err
+	}
+
+	val, err := value.Bytes()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return val, max(ttl.Val(), 0), true, nil
+}
+
 func (r *RedisCache) Delete(key string) {
 	r.client.Del(r.ctx, r.key(key))
 }
 
+// tagPrefix namespaces the sets that index surrogate-key tags.
+const tagPrefix = "surrogate:"
+
+// metaPrefix namespaces the values kept by SetMeta. Like tag sets, they are
+// not entries, so Purge and Enumerate skip them.
+const metaPrefix = "meta:"
+
+// internal reports whether the namespaced key holds a tag set or a meta
+// value rather than an entry.
+func (r *RedisCache) internal(key string) bool {
+	return strings.HasPrefix(key, r.key(tagPrefix)) || strings.HasPrefix(key, r.key(metaPrefix))
+}
+
+// SetMeta stores a value that never expires.
+func (r *RedisCache) SetMeta(name string, value []byte) error {
+	return r.client.Set(r.ctx, r.key(metaPrefix+name), value, 0).Err()
+}
+
+func (r *RedisCache) GetMeta(name string) ([]byte, bool, error) {
+	val, err := r.client.Get(r.ctx, r.key(metaPrefix+name)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// Tag adds key to the set of every tag. A set expires with the longest-lived
+// key it holds; setting and extending the expiry needs Redis 7.0 or newer.
+func (r *RedisCache) Tag(key string, tags []string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = r.defaultTTL
+	}
+
+	pipe := r.client.Pipeline()
+	for _, tag := range tags {
+		tagKey := r.key(tagPrefix + tag)
+		pipe.SAdd(r.ctx, tagKey, key)
+		if ttl > 0 {
+			pipe.ExpireNX(r.ctx, tagKey, ttl)
+			pipe.ExpireGT(r.ctx, tagKey, ttl)
+		}
+	}
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+// Untag deletes the tag's set and returns the keys it held.
+func (r *RedisCache) Untag(tag string) ([]string, error) {
+	tagKey := r.key(tagPrefix + tag)
+
+	pipe := r.client.TxPipeline()
+	members := pipe.SMembers(r.ctx, tagKey)
+	pipe.Del(r.ctx, tagKey)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, err
+	}
+	return members.Val(), nil
+}
+
+// Purge deletes every key in the namespace matching the glob pattern. It
+// walks the keyspace with SCAN so the server is never blocked by KEYS.
+func (r *RedisCache) Purge(pattern string) (int, error) {
+	match := regex.EscapeGlob(r.namespace) + pattern
+
+	purged := 0
+	iter := r.client.Scan(r.ctx, 0, match, 500).Iterator()
+	batch := make([]string, 0, 500)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		deleted, err := r.client.Del(r.ctx, batch...).Result()
+		purged += int(deleted)
+		batch = batch[:0]
+		return err
+	}
+
+	for iter.Next(r.ctx) {
+		// Tag sets are removed through Untag, and meta values stay.
+		if r.internal(iter.Val()) {
+			continue
+		}
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return purged, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return purged, err
+	}
+
+	return purged, flush()
+}
+
+// Enumerate calls fn with every entry in the namespace and its remaining
+// TTL, where 0 means the entry never expires. Keys are fetched in SCAN
+// batches, so entries written meanwhile may or may not be seen.
+func (r *RedisCache) Enumerate(fn func(key string, value []byte, ttl time.Duration) error) error {
+	iter := r.client.Scan(r.ctx, 0, regex.EscapeGlob(r.namespace)+"*", 500).Iterator()
+	batch := make([]string, 0, 500)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		pipe := r.client.Pipeline()
+		values := make([]*redis.StringCmd, len(batch))
+		ttls := make([]*redis.DurationCmd, len(batch))
+		for i, key := range batch {
+			values[i] = pipe.Get(r.ctx, key)
+			ttls[i] = pipe.PTTL(r.ctx, key)
+		}
+		if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+			return err
+		}
+
+		for i, key := range batch {
+			value, err := values[i].Bytes()
+			if err != nil {
+				// Expired or deleted since it was scanned.
+				continue
+			}
+			ttl := max(ttls[i].Val(), 0)
+			if err := fn(strings.TrimPrefix(key, r.namespace), value, ttl); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for iter.Next(r.ctx) {
+		if r.internal(iter.Val()) {
+			continue
+		}
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
 func (r *RedisCache) Len() int {
 	keys, err := r.client.Keys(r.ctx, r.namespace+"*").Result()
 	if err != nil {
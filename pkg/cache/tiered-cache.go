@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"time"
+)
+
+// Tier is what the tiered cache needs from the backend behind its memory
+// layer; DiskCache and RedisCache both satisfy it.
+type Tier interface {
+	Set(key string, value []byte, ttl time.Duration) error
+	Get(key string) ([]byte, bool, error)
+	// GetWithTtl also returns the entry's remaining TTL, where 0 means it
+	// never expires.
+	GetWithTtl(key string) ([]byte, time.Duration, bool, error)
+	Delete(key string)
+	Purge(pattern string) (int, error)
+	Tag(key string, tags []string, ttl time.Duration) error
+	Untag(tag string) ([]string, error)
+	Enumerate(fn func(key string, value []byte, ttl time.Duration) error) error
+	SetMeta(name string, value []byte) error
+	GetMeta(name string) ([]byte, bool, error)
+	Close() error
+}
+
+// TieredCache puts an in-memory LRU (L1) in front of a slower, shared or
+// persistent backend (L2). Reads try L1 first and promote L2 hits into it;
+// writes go to both.
+type TieredCache struct {
+	l1    *Cache
+	l2    Tier
+	l1Ttl time.Duration
+}
+
+// NewTieredCache wraps l2 with an L1 memory cache. Entries never live in L1
+// for longer than l1Ttl, which bounds how long an instance can serve a copy
+// that was already replaced or purged in a shared L2.
+func NewTieredCache(l1 *Cache, l2 Tier, l1Ttl time.Duration) *TieredCache {
+	return &TieredCache{l1: l1, l2: l2, l1Ttl: l1Ttl}
+}
+
+func (t *TieredCache) Set(key string, value []byte, ttl time.Duration) error {
+	if err := t.l2.Set(key, value, ttl); err != nil {
+		return err
+	}
+
+	// L1 is best effort: an entry too large for it is still in L2.
+	_ = t.l1.Set(key, value, t.l1TtlFor(ttl))
+	return nil
+}
+
+func (t *TieredCache) Get(key string) ([]byte, bool, error) {
+	if value, ok, _ := t.l1.Get(key); ok {
+		return value, true, nil
+	}
+
+	value, ttl, ok, err := t.l2.GetWithTtl(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	// A promoted copy must not outlive the L2 entry it was read from.
+	_ = t.l1.Set(key, value, t.l1TtlFor(ttl))
+	return value, true, nil
+}
+
+func (t *TieredCache) l1TtlFor(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < t.l1Ttl {
+		return ttl
+	}
+	return t.l1Ttl
+}
+
+func (t *TieredCache) Delete(key string) {
+	t.l1.Delete(key)
+	t.l2.Delete(key)
+}
+
+// The tag index lives in L2 alone. Untagged keys are removed through
+// Delete, which clears both layers.
+func (t *TieredCache) Tag(key string, tags []string, ttl time.Duration) error {
+	return t.l2.Tag(key, tags, ttl)
+}
+
+func (t *TieredCache) Untag(tag string) ([]string, error) {
+	return t.l2.Untag(tag)
+}
+
+// Meta values live in L2 alone, where every instance sharing it sees them.
+func (t *TieredCache) SetMeta(name string, value []byte) error {
+	return t.l2.SetMeta(name, value)
+}
+
+func (t *TieredCache) GetMeta(name string) ([]byte, bool, error) {
+	return t.l2.GetMeta(name)
+}
+
+// Enumerate walks L2, which holds every entry L1 does.
+func (t *TieredCache) Enumerate(fn func(key string, value []byte, ttl time.Duration) error) error {
+	return t.l2.Enumerate(fn)
+}
+
+// Purge reports the number of entries removed from L2, which holds every
+// entry L1 does.
+func (t *TieredCache) Purge(pattern string) (int, error) {
+	if _, err := t.l1.Purge(pattern); err != nil {
+		return 0, err
+	}
+	return t.l2.Purge(pattern)
+}
+
+// Len and Bytes report the L1 layer, which is what counts against the
+// instance's memory.
+func (t *TieredCache) Len() int {
+	return t.l1.Len()
+}
+
+func (t *TieredCache) Bytes() uint64 {
+	return t.l1.Bytes()
+}
+
+func (t *TieredCache) Close() error {
+	t.l1.Close()
+	return t.l2.Close()
+}
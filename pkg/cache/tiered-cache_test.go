@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTieredCache(t *testing.T, l1Ttl time.Duration) (*TieredCache, *Cache, *DiskCache) {
+	t.Helper()
+
+	l2, err := NewDiskCache(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1 := NewCache(100, 0)
+	tiered := NewTieredCache(l1, l2, l1Ttl)
+	t.Cleanup(func() { tiered.Close() })
+	return tiered, l1, l2
+}
+
+func TestTieredCachePromotesWithinL2Lifetime(t *testing.T) {
+	tests := []struct {
+		name  string
+		l2Ttl time.Duration
+		l1Ttl time.Duration
+		want  time.Duration
+	}{
+		{name: "short-lived entry", l2Ttl: 10 * time.Second, l1Ttl: time.Minute, want: 10 * time.Second},
+		{name: "long-lived entry", l2Ttl: time.Hour, l1Ttl: time.Minute, want: time.Minute},
+		{name: "entry that never expires", l2Ttl: 0, l1Ttl: time.Minute, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiered, l1, l2 := newTestTieredCache(t, tt.l1Ttl)
+			if err := l2.Set("key", []byte("value"), tt.l2Ttl); err != nil {
+				t.Fatal(err)
+			}
+
+			if value, ok, err := tiered.Get("key"); err != nil || !ok || string(value) != "value" {
+				t.Fatalf("Get = %q, %v, %v", value, ok, err)
+			}
+
+			var ttl time.Duration
+			l1.Enumerate(func(key string, value []byte, remaining time.Duration) error {
+				ttl = remaining
+				return nil
+			})
+			if ttl <= 0 || ttl > tt.want || ttl < tt.want-time.Second {
+				t.Errorf("promoted with TTL %s, want about %s", ttl, tt.want)
+			}
+		})
+	}
+}
+
+func TestTieredCacheWritesAndDeletesBothLayers(t *testing.T) {
+	tiered, l1, l2 := newTestTieredCache(t, time.Minute)
+
+	if err := tiered.Set("key", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := l1.Get("key"); !ok {
+		t.Error("Set skipped L1")
+	}
+	if _, ok, _ := l2.Get("key"); !ok {
+		t.Error("Set skipped L2")
+	}
+
+	tiered.Delete("key")
+	if _, ok, _ := l1.Get("key"); ok {
+		t.Error("Delete left the L1 copy")
+	}
+	if _, ok, _ := l2.Get("key"); ok {
+		t.Error("Delete left the L2 copy")
+	}
+}
+
+func TestDiskCacheGetWithTtl(t *testing.T) {
+	cache, err := NewDiskCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("expiring", []byte("a"), time.Minute)
+	cache.Set("forever", []byte("b"), 0)
+
+	if _, ttl, ok, err := cache.GetWithTtl("expiring"); err != nil || !ok || ttl <= 59*time.Second || ttl > time.Minute {
+		t.Errorf("expiring entry: ttl %s, ok %v, err %v", ttl, ok, err)
+	}
+	if _, ttl, ok, err := cache.GetWithTtl("forever"); err != nil || !ok || ttl != 0 {
+		t.Errorf("entry without expiry: ttl %s, ok %v, err %v", ttl, ok, err)
+	}
+}
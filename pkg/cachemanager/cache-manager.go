@@ -1,27 +1,157 @@
 package cachemanager
 
 import (
+	"errors"
+	"fmt"
 	"hermyx/pkg/models"
+	"hermyx/pkg/utils/regex"
+	"io"
 	"sort"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/valyala/fasthttp"
 )
 
 type ICache interface {
 	Set(key string, value []byte, ttl time.Duration) error
 	Get(key string) ([]byte, bool, error)
 	Delete(key string)
+	// Purge removes every key matching the Redis-style glob pattern and
+	// reports how many were removed.
+	Purge(pattern string) (int, error)
+	// Tag records that key carries each of the tags for as long as ttl, and
+	// Untag forgets a tag, returning the keys that carried it.
+	Tag(key string, tags []string, ttl time.Duration) error
+	Untag(tag string) ([]string, error)
+	// Enumerate calls fn with every live entry and its remaining TTL, where
+	// 0 means it never expires, until fn returns an error.
+	Enumerate(fn func(key string, value []byte, ttl time.Duration) error) error
+	// SetMeta and GetMeta keep small bookkeeping values beside the entries,
+	// where eviction, expiry, Purge and Enumerate never reach them.
+	SetMeta(name string, value []byte) error
+	GetMeta(name string) ([]byte, bool, error)
 	Close() error
 }
 
+// DefaultBackend names the backend built from the global cache config.
+// Routes share it unless they ask for their own type, capacity or Redis
+// settings.
+const DefaultBackend = "default"
+
+// RedisNamespace is the namespace a backend keeps its keys under when it
+// shares the Redis server and namespace of the global config. The default
+// backend and every route backend get sibling namespaces, so that scanning
+// one to purge or export it never reaches into another. Colons in route
+// names are escaped for the same reason.
+func RedisNamespace(namespace string, backend string) string {
+	if backend == DefaultBackend {
+		return namespace + DefaultBackend + ":"
+	}
+	return namespace + "route:" + namespaceEscaper.Replace(backend) + ":"
+}
+
+var namespaceEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// BackendFactory builds the ICache for a named backend from its resolved
+// cache config.
+type BackendFactory func(name string, config *models.CacheConfig) (ICache, error)
+
+// UsageReporter is implemented by backends that can report how much they
+// currently hold.
+type UsageReporter interface {
+	Len() int
+	Bytes() uint64
+}
+
+type Usage struct {
+	Entries int
+	Bytes   uint64
+}
+
 type CacheManager struct {
-	cache ICache
+	factory  BackendFactory
+	backends map[string]ICache
+	// ttls holds the configured TTL of each backend, given to imported
+	// entries that never expired in the backend they were exported from.
+	ttls map[string]time.Duration
+	mu   sync.RWMutex
+}
+
+func NewCacheManager(factory BackendFactory) *CacheManager {
+	return &CacheManager{
+		factory:  factory,
+		backends: make(map[string]ICache),
+		ttls:     make(map[string]time.Duration),
+	}
 }
 
-func NewCacheManager(cache ICache) *CacheManager {
-	return &CacheManager{cache: cache}
+// Backend returns the backend registered under name, building it from config
+// on first use.
+func (cm *CacheManager) Backend(name string, config *models.CacheConfig) (ICache, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if backend, ok := cm.backends[name]; ok {
+		return backend, nil
+	}
+
+	backend, err := cm.factory(name, config)
+	if err != nil {
+		return nil, err
+	}
+	cm.backends[name] = backend
+	if config != nil {
+		cm.ttls[name] = config.Ttl
+	}
+	return backend, nil
+}
+
+// ResolveBackend picks the backend a route's cache lives in. A route gets a
+// backend of its own, named after the route, when its config asks for a
+// different type or capacity, or brings its own Redis settings; otherwise
+// it shares the default backend. It must be called before Resolve, which
+// fills the route config in from the engine config.
+func (cm *CacheManager) ResolveBackend(routeName string, engineConfig *models.CacheConfig, routeConfig *models.CacheConfig) (string, error) {
+	if routeConfig == nil || !routeConfig.Enabled || engineConfig == nil {
+		return DefaultBackend, nil
+	}
+
+	dedicated := (routeConfig.Type != "" && routeConfig.Type != engineConfig.Type) ||
+		(routeConfig.Capacity != 0 && routeConfig.Capacity != engineConfig.Capacity) ||
+		(routeConfig.MaxBytes != 0 && routeConfig.MaxBytes != engineConfig.MaxBytes) ||
+		routeConfig.Redis != nil || routeConfig.Tiered != nil
+	if !dedicated {
+		return DefaultBackend, nil
+	}
+
+	config := *routeConfig
+	if config.Type == "" {
+		config.Type = engineConfig.Type
+	}
+	if config.Capacity == 0 {
+		config.Capacity = engineConfig.Capacity
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = engineConfig.MaxBytes
+	}
+	if config.Tiered == nil {
+		config.Tiered = engineConfig.Tiered
+	}
+	if config.Ttl == 0 {
+		config.Ttl = engineConfig.Ttl
+	}
+	if config.Redis == nil && engineConfig.Redis != nil {
+		// Share the server but keep the route's keys under a namespace of
+		// their own.
+		redisConfig := *engineConfig.Redis
+		redisConfig.KeyNamespace = RedisNamespace(redisConfig.KeyNamespace, routeName)
+		config.Redis = &redisConfig
+	}
+
+	if _, err := cm.Backend(routeName, &config); err != nil {
+		return "", err
+	}
+	return routeName, nil
 }
 
 func (cm *CacheManager) Resolve(engineConfig *models.CacheConfig, routeConfig *models.CacheConfig) *models.CacheConfig {
@@ -35,6 +165,18 @@ func (cm *CacheManager) Resolve(engineConfig *models.CacheConfig, routeConfig *m
 
 	config := routeConfig
 
+	if config.Type == "" && engineConfig != nil {
+		config.Type = engineConfig.Type
+	}
+
+	if config.Capacity == 0 && engineConfig != nil {
+		config.Capacity = engineConfig.Capacity
+	}
+
+	if config.MaxBytes == 0 && engineConfig != nil {
+		config.MaxBytes = engineConfig.MaxBytes
+	}
+
 	if config.Ttl == 0 && engineConfig != nil {
 		config.Ttl = engineConfig.Ttl
 	}
@@ -47,56 +189,285 @@ func (cm *CacheManager) Resolve(engineConfig *models.CacheConfig, routeConfig *m
 		config.MaxContentSize = engineConfig.MaxContentSize
 	}
 
+	if config.RespectOriginHeaders == nil && engineConfig != nil {
+		config.RespectOriginHeaders = engineConfig.RespectOriginHeaders
+	}
+
+	if config.GenerateEtag == nil && engineConfig != nil {
+		config.GenerateEtag = engineConfig.GenerateEtag
+	}
+
+	if config.GracePeriod == nil && engineConfig != nil {
+		config.GracePeriod = engineConfig.GracePeriod
+	}
+
+	if config.StaleWhileRevalidate == nil && engineConfig != nil {
+		config.StaleWhileRevalidate = engineConfig.StaleWhileRevalidate
+	}
+
+	if config.StaleIfError == nil && engineConfig != nil {
+		config.StaleIfError = engineConfig.StaleIfError
+	}
+
+	if config.CoalesceTimeout == 0 && engineConfig != nil {
+		config.CoalesceTimeout = engineConfig.CoalesceTimeout
+	}
+
+	if config.DisableCoalescing == nil && engineConfig != nil {
+		config.DisableCoalescing = engineConfig.DisableCoalescing
+	}
+
+	if config.SurrogateKeyHeader == "" && engineConfig != nil {
+		config.SurrogateKeyHeader = engineConfig.SurrogateKeyHeader
+	}
+
+	if config.InvalidateOnUnsafe == nil && engineConfig != nil {
+		config.InvalidateOnUnsafe = engineConfig.InvalidateOnUnsafe
+	}
+
+	if config.Compression == "" && engineConfig != nil {
+		config.Compression = engineConfig.Compression
+	}
+
+	if config.CompressionMinSize == 0 && engineConfig != nil {
+		config.CompressionMinSize = engineConfig.CompressionMinSize
+	}
+
+	if config.EdgeCompression == nil && engineConfig != nil {
+		config.EdgeCompression = engineConfig.EdgeCompression
+	}
+
+	if config.StatusTtl == nil && engineConfig != nil {
+		config.StatusTtl = engineConfig.StatusTtl
+	}
+
+	if config.TtlJitter == nil && engineConfig != nil {
+		config.TtlJitter = engineConfig.TtlJitter
+	}
+
+	if config.EarlyRefresh == nil && engineConfig != nil {
+		config.EarlyRefresh = engineConfig.EarlyRefresh
+	}
+
 	sort.Strings(config.KeyConfig.Type)
 
 	return config
 }
 
-func (cm *CacheManager) Set(key string, value []byte, ttl time.Duration) {
-	cm.cache.Set(key, value, ttl)
+// Set stores the response as fresh for ttl. The backend keeps it for a
+// further stale period so that it can still be revalidated or served once
+// its freshness has run out. A non-zero jitter shortens ttl by a random
+// fraction of up to jitter, so that entries stored together do not all
+// expire together.
+func (cm *CacheManager) Set(backend string, key string, response *CachedResponse, ttl time.Duration, stale time.Duration, jitter float64) error {
+	cache, err := cm.lookup(backend)
+	if err != nil {
+		return err
+	}
+
+	ttl = jitterTtl(ttl, jitter)
+	response.ExpiresAt = time.Now().Add(ttl)
+	if err := cache.Set(key, response.Encode(), ttl+stale); err != nil {
+		return err
+	}
+
+	if len(response.Tags) > 0 {
+		return cache.Tag(key, response.Tags, ttl+stale)
+	}
+	return nil
 }
 
-func (cm *CacheManager) Get(key string) ([]byte, bool, error) {
-	return cm.cache.Get(key)
+func (cm *CacheManager) Get(backend string, key string) (*CachedResponse, bool, error) {
+	cache, err := cm.lookup(backend)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, exists, err := cache.Get(key)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+
+	response, err := DecodeCachedResponse(value)
+	if err != nil {
+		// Entries written by an incompatible layout are unusable; drop them
+		// so the next response replaces them.
+		cache.Delete(key)
+		return nil, false, err
+	}
+
+	return response, true, nil
+}
+
+func (cm *CacheManager) Delete(backend string, key string) {
+	if cache, err := cm.lookup(backend); err == nil {
+		cache.Delete(key)
+	}
 }
 
-func (cm *CacheManager) GetKey(cacheKeyConfig *models.CacheKeyConfig, ctx *fasthttp.RequestCtx) string {
-	var keyParts []string
+// Purge removes the keys matching the glob pattern from every backend.
+func (cm *CacheManager) Purge(pattern string) (int, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
-	for _, keyType := range cacheKeyConfig.Type {
-		switch keyType {
-		case models.CACHE_KEY_METHOD:
-			keyParts = append(keyParts, strings.ToLower(string(ctx.Method())))
-		case models.CACHE_KEY_PATH:
-			keyParts = append(keyParts, string(ctx.Path()))
-		case models.CACHE_KEY_QUERY:
-			keyParts = append(keyParts, string(ctx.QueryArgs().QueryString()))
-		case models.CACHE_KEY_HEADER:
-			if cacheKeyConfig.Headers == nil || len(cacheKeyConfig.Headers) == 0 {
-				continue
+	purged := 0
+	var errs []error
+	for name, cache := range cm.backends {
+		count, err := cache.Purge(pattern)
+		purged += count
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purging cache backend %q: %w", name, err))
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// PurgeKey removes an exact key together with the variants stored under it.
+func (cm *CacheManager) PurgeKey(key string) (int, error) {
+	purged, err := cm.Purge(regex.EscapeGlob(key))
+	if err != nil {
+		return purged, err
+	}
+
+	variants, err := cm.Purge(regex.EscapeGlob(variantPrefix(key)) + "*")
+	return purged + variants, err
+}
+
+func (cm *CacheManager) PurgePrefix(prefix string) (int, error) {
+	return cm.Purge(regex.EscapeGlob(prefix) + "*")
+}
+
+// PurgeRoute removes every entry of the route, including the bare route key
+// a route without key parts caches under.
+func (cm *CacheManager) PurgeRoute(routeName string) (int, error) {
+	purged, err := cm.PurgeKey(routeName)
+	if err != nil {
+		return purged, err
+	}
+
+	rest, err := cm.PurgePrefix(routeName + "|")
+	return purged + rest, err
+}
+
+// PurgeTag removes every entry carrying the surrogate-key tag from every
+// backend.
+func (cm *CacheManager) PurgeTag(tag string) (int, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	purged := 0
+	var errs []error
+	for name, cache := range cm.backends {
+		keys, err := cache.Untag(tag)
+		for _, key := range keys {
+			cache.Delete(key)
+		}
+		purged += len(keys)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purging tag %q from cache backend %q: %w", tag, name, err))
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// Export writes every live entry of every backend to the snapshot.
+func (cm *CacheManager) Export(writer *SnapshotWriter) (int, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	exported := 0
+	for name, cache := range cm.backends {
+		err := cache.Enumerate(func(key string, value []byte, ttl time.Duration) error {
+			exported++
+			return writer.Write(SnapshotEntry{Backend: name, Key: key, Value: value, Ttl: ttl})
+		})
+		if err != nil {
+			return exported, fmt.Errorf("exporting cache backend %q: %w", name, err)
+		}
+	}
+	return exported, nil
+}
+
+// Import stores every entry of the snapshot with its remaining TTL. Entries
+// of a backend this manager does not have go to the default backend, and
+// the surrogate-key tags recorded in each envelope are indexed again.
+// Entries that never expired (TTL 0) get the configured TTL of the backend
+// they are stored in, so that every backend type keeps them for the same
+// time; they are skipped when that backend has no TTL configured.
+func (cm *CacheManager) Import(r io.Reader) (int, error) {
+	imported := 0
+	err := ReadSnapshot(r, func(entry SnapshotEntry) error {
+		backend := entry.Backend
+		cache, err := cm.lookup(backend)
+		if err != nil {
+			backend = DefaultBackend
+			if cache, err = cm.lookup(backend); err != nil {
+				return err
 			}
+		}
 
-			for _, header := range cacheKeyConfig.Headers {
-				if header == nil {
-					continue
-				}
+		ttl := entry.Ttl
+		if ttl == 0 {
+			if ttl = cm.backendTtl(backend); ttl <= 0 {
+				return nil
+			}
+		}
 
-				keyPart := string(ctx.Request.Header.Peek(header.Key))
-				if len(keyPart) != 0 {
-					keyParts = append(keyParts, keyPart)
-				}
+		if err := cache.Set(entry.Key, entry.Value, ttl); err != nil {
+			return fmt.Errorf("importing key %s: %w", entry.Key, err)
+		}
+		if response, err := DecodeCachedResponse(entry.Value); err == nil && len(response.Tags) > 0 {
+			if err := cache.Tag(entry.Key, response.Tags, ttl); err != nil {
+				return fmt.Errorf("importing tags of key %s: %w", entry.Key, err)
 			}
+		}
+		imported++
+		return nil
+	})
+	return imported, err
+}
+
+// Usage reports the current size of every backend that supports it.
+func (cm *CacheManager) Usage() map[string]Usage {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
+	usage := make(map[string]Usage)
+	for name, cache := range cm.backends {
+		if reporter, ok := cache.(UsageReporter); ok {
+			usage[name] = Usage{Entries: reporter.Len(), Bytes: reporter.Bytes()}
 		}
 	}
+	return usage
+}
+
+func (cm *CacheManager) backendTtl(backend string) time.Duration {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
-	return strings.Join(keyParts, "|")
+	return cm.ttls[backend]
 }
 
-func (cm *CacheManager) Delete(key string) {
-	cm.cache.Delete(key)
+func (cm *CacheManager) lookup(backend string) (ICache, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	cache, ok := cm.backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("cache backend %q is not configured", backend)
+	}
+	return cache, nil
 }
 
 func (cm *CacheManager) Close() error {
-	return cm.cache.Close()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var errs []error
+	for name, cache := range cm.backends {
+		if err := cache.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing cache backend %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,155 @@
+package cachemanager
+
+import (
+	"hermyx/pkg/models"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestResolve(t *testing.T) {
+	const global = `
+type: memory
+ttl: 1m
+keyConfig: {type: [path, method]}
+respectOriginHeaders: true
+generateEtag: true
+disableCoalescing: true
+invalidateOnUnsafe: true
+edgeCompression: true
+gracePeriod: 1m
+staleWhileRevalidate: 2m
+staleIfError: 3m
+ttlJitter: 0.1
+earlyRefresh: 1
+`
+	type settings struct {
+		respectOriginHeaders, generateEtag, disableCoalescing, invalidateOnUnsafe, edgeCompression bool
+		gracePeriod, staleWhileRevalidate, staleIfError                                            time.Duration
+		ttlJitter, earlyRefresh                                                                    float64
+	}
+	inherited := settings{true, true, true, true, true, time.Minute, 2 * time.Minute, 3 * time.Minute, 0.1, 1}
+
+	tests := []struct {
+		name  string
+		route string
+		want  settings
+	}{
+		{name: "inherits everything", route: `enabled: true`, want: inherited},
+		{
+			name: "turns everything off",
+			route: `
+enabled: true
+respectOriginHeaders: false
+generateEtag: false
+disableCoalescing: false
+invalidateOnUnsafe: false
+edgeCompression: false
+gracePeriod: 0s
+staleWhileRevalidate: 0s
+staleIfError: 0s
+ttlJitter: 0
+earlyRefresh: 0
+`,
+		},
+		{
+			name:  "overrides some",
+			route: "enabled: true\nedgeCompression: false\nstaleIfError: 10s",
+			want:  settings{true, true, true, true, false, time.Minute, 2 * time.Minute, 10 * time.Second, 0.1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var engineConfig, routeConfig models.CacheConfig
+			if err := yaml.Unmarshal([]byte(global), &engineConfig); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.route), &routeConfig); err != nil {
+				t.Fatal(err)
+			}
+
+			config := NewCacheManager(nil).Resolve(&engineConfig, &routeConfig)
+			got := settings{
+				models.Setting(config.RespectOriginHeaders),
+				models.Setting(config.GenerateEtag),
+				models.Setting(config.DisableCoalescing),
+				models.Setting(config.InvalidateOnUnsafe),
+				models.Setting(config.EdgeCompression),
+				models.Setting(config.GracePeriod),
+				models.Setting(config.StaleWhileRevalidate),
+				models.Setting(config.StaleIfError),
+				models.Setting(config.TtlJitter),
+				models.Setting(config.EarlyRefresh),
+			}
+			if got != tt.want {
+				t.Errorf("resolved %+v, want %+v", got, tt.want)
+			}
+			if config.Type != "memory" || config.Ttl != time.Minute || config.KeyConfig.Type[0] != "method" {
+				t.Errorf("resolved type %q, ttl %s, key %v from the global config", config.Type, config.Ttl, config.KeyConfig.Type)
+			}
+		})
+	}
+}
+
+func TestResolveBackend(t *testing.T) {
+	engineConfig := &models.CacheConfig{
+		Type:     models.CACHE_TYPE_REDIS,
+		Capacity: 100,
+		Redis:    &models.RedisConfig{Address: "redis:6379", KeyNamespace: "hermyx:"},
+	}
+	own := &models.RedisConfig{Address: "other:6379", KeyNamespace: "mine:"}
+
+	tests := []struct {
+		name      string
+		route     *models.CacheConfig
+		backend   string
+		namespace string
+	}{
+		{name: "no cache config", backend: DefaultBackend},
+		{name: "disabled", route: &models.CacheConfig{Type: models.CACHE_TYPE_MEMORY}, backend: DefaultBackend},
+		{name: "same settings", route: &models.CacheConfig{Enabled: true, Type: models.CACHE_TYPE_REDIS, Capacity: 100}, backend: DefaultBackend},
+		{name: "own capacity", route: &models.CacheConfig{Enabled: true, Capacity: 5}, backend: "api", namespace: "hermyx:route:api:"},
+		{name: "own redis", route: &models.CacheConfig{Enabled: true, Redis: own}, backend: "api", namespace: "mine:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var built *models.CacheConfig
+			manager := NewCacheManager(func(name string, config *models.CacheConfig) (ICache, error) {
+				built = config
+				return nil, nil
+			})
+
+			backend, err := manager.ResolveBackend("api", engineConfig, tt.route)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backend != tt.backend {
+				t.Errorf("backend = %q, want %q", backend, tt.backend)
+			}
+			if tt.namespace != "" && (built == nil || built.Redis.KeyNamespace != tt.namespace) {
+				t.Errorf("built %+v, want namespace %q", built, tt.namespace)
+			}
+		})
+	}
+	if engineConfig.Redis.KeyNamespace != "hermyx:" {
+		t.Errorf("global namespace changed to %q", engineConfig.Redis.KeyNamespace)
+	}
+}
+
+func TestRedisNamespacesDoNotNest(t *testing.T) {
+	backends := []string{DefaultBackend, "api", "api:v2", "api%3Av2", "route"}
+	for _, a := range backends {
+		for _, b := range backends {
+			if a == b {
+				continue
+			}
+			if strings.HasPrefix(RedisNamespace("hermyx:", a), RedisNamespace("hermyx:", b)) {
+				t.Errorf("namespace of %q nests inside that of %q", a, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package cachemanager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Every cached value starts with this magic followed by a version byte so
+// that entries written by an older layout can be recognised. Version 2
+// appends a list of tagged metadata fields after the body; unknown fields
+// are skipped, so new metadata does not need another version bump.
+const (
+	cachedResponseMagic   = "HMX"
+	cachedResponseVersion = 2
+)
+
+const (
+	fieldStoredAt byte = iota + 1
+	fieldVary
+	fieldExpiresAt
+	fieldTags
+	fieldBodyEncoding
+	fieldFetchDuration
+)
+
+var ErrInvalidCachedResponse = errors.New("invalid cached response")
+
+// unstoredHeaders are never stored with a response. Most are hop-by-hop:
+// they describe the upstream connection rather than the response itself.
+// Content-Length is framing that fasthttp regenerates from the replayed
+// body. Set-Cookie is dropped because it usually carries one client's
+// session, and replaying it from a shared cache would hand that session to
+// every other client.
+var unstoredHeaders = map[string]struct{}{
+	"connection":          {},
+	"keep-alive":          {},
+	"proxy-authenticate":  {},
+	"proxy-authorization": {},
+	"te":                  {},
+	"trailer":             {},
+	"transfer-encoding":   {},
+	"upgrade":             {},
+	"content-length":      {},
+	"set-cookie":          {},
+}
+
+type CachedHeader struct {
+	Key   string
+	Value string
+}
+
+// CachedResponse is the envelope stored in every ICache backend. It holds
+// the full upstream response so a hit can be replayed exactly.
+type CachedResponse struct {
+	StatusCode int
+	Headers    []CachedHeader
+	Body       []byte
+	StoredAt   time.Time
+
+	// ExpiresAt is the end of the freshness lifetime. The backend may keep
+	// the entry for longer so that it can be revalidated once stale.
+	ExpiresAt time.Time
+
+	// Vary is only set on vary markers: entries stored under the base key
+	// that name the request headers selecting the actual variant.
+	Vary []string
+
+	// Tags are the surrogate keys the upstream labelled the response with.
+	Tags []string
+
+	// BodyEncoding names the compression Hermyx applied to Body before
+	// storing it. It is empty when Body holds the upstream bytes as-is.
+	BodyEncoding string
+
+	// FetchDuration is how long the upstream took to produce the response,
+	// which early refresh weighs against the time left until ExpiresAt.
+	FetchDuration time.Duration
+}
+
+func NewCachedResponse(resp *fasthttp.Response) *CachedResponse {
+	cached := &CachedResponse{
+		StatusCode: resp.StatusCode(),
+		Body:       append([]byte(nil), resp.Body()...),
+		StoredAt:   time.Now(),
+	}
+
+	resp.Header.VisitAll(func(key, value []byte) {
+		if _, skip := unstoredHeaders[strings.ToLower(string(key))]; skip {
+			return
+		}
+		cached.Headers = append(cached.Headers, CachedHeader{Key: string(key), Value: string(value)})
+	})
+
+	return cached
+}
+
+func NewVaryMarker(vary []string) *CachedResponse {
+	return &CachedResponse{StoredAt: time.Now(), Vary: vary}
+}
+
+func (r *CachedResponse) IsVaryMarker() bool {
+	return len(r.Vary) > 0
+}
+
+func (r *CachedResponse) IsFresh() bool {
+	return r.ExpiresAt.IsZero() || time.Now().Before(r.ExpiresAt)
+}
+
+// HasValidators reports whether the upstream can be asked to revalidate
+// the entry with a conditional request.
+func (r *CachedResponse) HasValidators() bool {
+	return r.Header(fasthttp.HeaderETag) != "" || r.Header(fasthttp.HeaderLastModified) != ""
+}
+
+// Refresh merges the headers of a 304 revalidation response into the
+// stored ones and restarts the entry's age (RFC 9111 §4.3.4).
+func (r *CachedResponse) Refresh(header *fasthttp.ResponseHeader) {
+	updated := make(map[string][]string)
+	header.VisitAll(func(key, value []byte) {
+		name := strings.ToLower(string(key))
+		if _, skip := unstoredHeaders[name]; skip {
+			return
+		}
+		// A 304 describes the same representation; fasthttp reports a
+		// default Content-Type for it that must not replace the stored one.
+		if name == "content-type" || name == "content-encoding" {
+			return
+		}
+		updated[name] = append(updated[name], string(value))
+	})
+
+	headers := r.Headers[:0:0]
+	for _, stored := range r.Headers {
+		if _, replaced := updated[strings.ToLower(stored.Key)]; !replaced {
+			headers = append(headers, stored)
+		}
+	}
+	header.VisitAll(func(key, value []byte) {
+		if _, ok := updated[strings.ToLower(string(key))]; ok {
+			headers = append(headers, CachedHeader{Key: string(key), Value: string(value)})
+		}
+	})
+
+	r.Headers = headers
+	r.StoredAt = time.Now()
+}
+
+// Age is the time elapsed since the response was stored.
+func (r *CachedResponse) Age() time.Duration {
+	if r.StoredAt.IsZero() {
+		return 0
+	}
+	return time.Since(r.StoredAt)
+}
+
+// Header returns the first stored value of the given header.
+func (r *CachedResponse) Header(key string) string {
+	for _, header := range r.Headers {
+		if strings.EqualFold(header.Key, key) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// WriteTo replays the stored status, headers and body onto resp. A body
+// compressed at rest is sent as it is stored when acceptEncoding allows its
+// encoding, and decompressed otherwise.
+func (r *CachedResponse) WriteTo(resp *fasthttp.Response, acceptEncoding []byte) error {
+	resp.SetStatusCode(r.StatusCode)
+	for _, header := range r.Headers {
+		resp.Header.Add(header.Key, header.Value)
+	}
+
+	if r.BodyEncoding == "" {
+		resp.SetBody(r.Body)
+		return nil
+	}
+	return r.writeEncodedBody(resp, acceptEncoding)
+}
+
+func (r *CachedResponse) Encode() []byte {
+	fields := r.fields()
+
+	size := len(cachedResponseMagic) + 1 + 2 + 2 + 4 + len(r.Body) + 1
+	for _, header := range r.Headers {
+		size += 2 + len(header.Key) + 4 + len(header.Value)
+	}
+	for _, field := range fields {
+		size += 1 + 4 + len(field.value)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, cachedResponseMagic...)
+	buf = append(buf, cachedResponseVersion)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.StatusCode))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(r.Headers)))
+	for _, header := range r.Headers {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(header.Key)))
+		buf = append(buf, header.Key...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(header.Value)))
+		buf = append(buf, header.Value...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.Body)))
+	buf = append(buf, r.Body...)
+
+	buf = append(buf, byte(len(fields)))
+	for _, field := range fields {
+		buf = append(buf, field.id)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(field.value)))
+		buf = append(buf, field.value...)
+	}
+
+	return buf
+}
+
+type envelopeField struct {
+	id    byte
+	value []byte
+}
+
+func (r *CachedResponse) fields() []envelopeField {
+	var fields []envelopeField
+
+	if !r.StoredAt.IsZero() {
+		fields = append(fields, envelopeField{fieldStoredAt, binary.BigEndian.AppendUint64(nil, uint64(r.StoredAt.UnixNano()))})
+	}
+	if len(r.Vary) > 0 {
+		fields = append(fields, envelopeField{fieldVary, []byte(strings.Join(r.Vary, "\n"))})
+	}
+	if !r.ExpiresAt.IsZero() {
+		fields = append(fields, envelopeField{fieldExpiresAt, binary.BigEndian.AppendUint64(nil, uint64(r.ExpiresAt.UnixNano()))})
+	}
+	if len(r.Tags) > 0 {
+		fields = append(fields, envelopeField{fieldTags, []byte(strings.Join(r.Tags, "\n"))})
+	}
+	if r.BodyEncoding != "" {
+		fields = append(fields, envelopeField{fieldBodyEncoding, []byte(r.BodyEncoding)})
+	}
+	if r.FetchDuration > 0 {
+		fields = append(fields, envelopeField{fieldFetchDuration, binary.BigEndian.AppendUint64(nil, uint64(r.FetchDuration))})
+	}
+
+	return fields
+}
+
+func (r *CachedResponse) setField(id byte, value []byte) {
+	switch id {
+	case fieldStoredAt:
+		if len(value) == 8 {
+			r.StoredAt = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		}
+	case fieldVary:
+		r.Vary = strings.Split(string(value), "\n")
+	case fieldExpiresAt:
+		if len(value) == 8 {
+			r.ExpiresAt = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		}
+	case fieldTags:
+		r.Tags = strings.Split(string(value), "\n")
+	case fieldBodyEncoding:
+		r.BodyEncoding = string(value)
+	case fieldFetchDuration:
+		if len(value) == 8 {
+			r.FetchDuration = time.Duration(binary.BigEndian.Uint64(value))
+		}
+	}
+}
+
+func DecodeCachedResponse(data []byte) (*CachedResponse, error) {
+	reader := envelopeReader{data: data}
+
+	if string(reader.next(len(cachedResponseMagic))) != cachedResponseMagic {
+		return nil, ErrInvalidCachedResponse
+	}
+	version := reader.next(1)
+	if version == nil {
+		return nil, ErrInvalidCachedResponse
+	}
+	if version[0] < 1 || version[0] > cachedResponseVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCachedResponse, version[0])
+	}
+
+	cached := &CachedResponse{StatusCode: int(reader.uint16())}
+
+	headerCount := int(reader.uint16())
+	for i := 0; i < headerCount && reader.err == nil; i++ {
+		key := string(reader.next(int(reader.uint16())))
+		value := string(reader.next(int(reader.uint32())))
+		cached.Headers = append(cached.Headers, CachedHeader{Key: key, Value: value})
+	}
+
+	cached.Body = append([]byte(nil), reader.next(int(reader.uint32()))...)
+
+	if version[0] >= 2 {
+		fieldCount := reader.next(1)
+		for i := 0; fieldCount != nil && i < int(fieldCount[0]) && reader.err == nil; i++ {
+			id := reader.next(1)
+			value := reader.next(int(reader.uint32()))
+			if reader.err == nil {
+				cached.setField(id[0], value)
+			}
+		}
+	}
+
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	return cached, nil
+}
+
+type envelopeReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *envelopeReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.offset+n > len(r.data) {
+		r.err = fmt.Errorf("%w: truncated data", ErrInvalidCachedResponse)
+		return nil
+	}
+	chunk := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return chunk
+}
+
+func (r *envelopeReader) uint16() uint16 {
+	chunk := r.next(2)
+	if chunk == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(chunk)
+}
+
+func (r *envelopeReader) uint32() uint32 {
+	chunk := r.next(4)
+	if chunk == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(chunk)
+}
@@ -0,0 +1,219 @@
+package cachemanager
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestNewCachedResponseSkipsUnstoredHeaders(t *testing.T) {
+	var resp fasthttp.Response
+	resp.SetStatusCode(fasthttp.StatusCreated)
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Connection", "keep-alive")
+	resp.Header.Set("Set-Cookie", "session=secret")
+	resp.Header.Add("X-Multi", "a")
+	resp.Header.Add("X-Multi", "b")
+	resp.SetBodyString(`{"a":1}`)
+
+	cached := NewCachedResponse(&resp)
+
+	if cached.StatusCode != fasthttp.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", cached.StatusCode, fasthttp.StatusCreated)
+	}
+	if string(cached.Body) != `{"a":1}` {
+		t.Errorf("Body = %q", cached.Body)
+	}
+	for _, name := range []string{"Connection", "Set-Cookie", "Content-Length"} {
+		if value := cached.Header(name); value != "" {
+			t.Errorf("header %s was stored as %q", name, value)
+		}
+	}
+
+	var multi []string
+	for _, header := range cached.Headers {
+		if header.Key == "X-Multi" {
+			multi = append(multi, header.Value)
+		}
+	}
+	if !reflect.DeepEqual(multi, []string{"a", "b"}) {
+		t.Errorf("X-Multi = %v, want both values", multi)
+	}
+}
+
+func TestCachedResponseRoundTrip(t *testing.T) {
+	storedAt := time.Unix(1700000000, 123).UTC()
+	tests := []struct {
+		name     string
+		response *CachedResponse
+	}{
+		{
+			name:     "empty",
+			response: &CachedResponse{StatusCode: 200},
+		},
+		{
+			name: "full",
+			response: &CachedResponse{
+				StatusCode:    404,
+				Headers:       []CachedHeader{{Key: "Content-Type", Value: "text/plain"}, {Key: "ETag", Value: `"v1"`}},
+				Body:          []byte("not found"),
+				StoredAt:      storedAt,
+				ExpiresAt:     storedAt.Add(time.Minute),
+				Tags:          []string{"a", "b"},
+				BodyEncoding:  "gzip",
+				FetchDuration: 150 * time.Millisecond,
+			},
+		},
+		{
+			name:     "vary marker",
+			response: &CachedResponse{StoredAt: storedAt, Vary: []string{"accept-language", "x-user"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeCachedResponse(tt.response.Encode())
+			if err != nil {
+				t.Fatalf("DecodeCachedResponse: %v", err)
+			}
+
+			if !decoded.StoredAt.Equal(tt.response.StoredAt) || !decoded.ExpiresAt.Equal(tt.response.ExpiresAt) {
+				t.Errorf("times = %v/%v, want %v/%v", decoded.StoredAt, decoded.ExpiresAt, tt.response.StoredAt, tt.response.ExpiresAt)
+			}
+			decoded.StoredAt, decoded.ExpiresAt = tt.response.StoredAt, tt.response.ExpiresAt
+			if !reflect.DeepEqual(decoded, tt.response) {
+				t.Errorf("decoded = %+v, want %+v", decoded, tt.response)
+			}
+		})
+	}
+}
+
+func TestDecodeCachedResponseVersion1(t *testing.T) {
+	response := &CachedResponse{StatusCode: 200, Headers: []CachedHeader{{Key: "A", Value: "b"}}, Body: []byte("body")}
+	encoded := response.Encode()
+
+	// Version 1 is the version 2 layout without the field trailer.
+	v1 := append([]byte(nil), encoded[:len(encoded)-1]...)
+	v1[len(cachedResponseMagic)] = 1
+
+	decoded, err := DecodeCachedResponse(v1)
+	if err != nil {
+		t.Fatalf("DecodeCachedResponse: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, response) {
+		t.Errorf("decoded = %+v, want %+v", decoded, response)
+	}
+}
+
+func TestDecodeCachedResponseSkipsUnknownFields(t *testing.T) {
+	encoded := (&CachedResponse{StatusCode: 200, Body: []byte("x"), BodyEncoding: "br"}).Encode()
+
+	// Replace the trailer with an unknown field followed by a known one.
+	trailer := len(encoded) - (1 + 1 + 4 + len("br"))
+	data := append([]byte(nil), encoded[:trailer]...)
+	data = append(data, 2, 200)
+	data = binary.BigEndian.AppendUint32(data, 3)
+	data = append(data, "new"...)
+	data = append(data, fieldBodyEncoding)
+	data = binary.BigEndian.AppendUint32(data, 2)
+	data = append(data, "br"...)
+
+	decoded, err := DecodeCachedResponse(data)
+	if err != nil {
+		t.Fatalf("DecodeCachedResponse: %v", err)
+	}
+	if decoded.BodyEncoding != "br" {
+		t.Errorf("BodyEncoding = %q, want br", decoded.BodyEncoding)
+	}
+}
+
+func TestDecodeCachedResponseRejectsInvalidData(t *testing.T) {
+	valid := (&CachedResponse{
+		StatusCode: 200,
+		Headers:    []CachedHeader{{Key: "Content-Type", Value: "text/plain"}},
+		Body:       []byte("hello"),
+		StoredAt:   time.Now(),
+	}).Encode()
+
+	unsupported := append([]byte(nil), valid...)
+	unsupported[len(cachedResponseMagic)] = cachedResponseVersion + 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"raw body", []byte("hello world")},
+		{"magic only", []byte(cachedResponseMagic)},
+		{"version zero", append([]byte(cachedResponseMagic), 0)},
+		{"unsupported version", unsupported},
+	}
+	for cut := len(cachedResponseMagic) + 1; cut < len(valid); cut++ {
+		tests = append(tests, struct {
+			name string
+			data []byte
+		}{"truncated", valid[:cut]})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCachedResponse(tt.data); !errors.Is(err, ErrInvalidCachedResponse) {
+				t.Errorf("DecodeCachedResponse(%d bytes) error = %v, want ErrInvalidCachedResponse", len(tt.data), err)
+			}
+		})
+	}
+}
+
+func TestCachedResponseWriteTo(t *testing.T) {
+	cached := &CachedResponse{
+		StatusCode: 301,
+		Headers:    []CachedHeader{{Key: "Location", Value: "/new"}, {Key: "X-Multi", Value: "a"}, {Key: "X-Multi", Value: "b"}},
+		Body:       []byte("moved"),
+	}
+
+	var resp fasthttp.Response
+	if err := cached.WriteTo(&resp, nil); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if resp.StatusCode() != 301 || string(resp.Header.Peek("Location")) != "/new" || string(resp.Body()) != "moved" {
+		t.Errorf("replayed %d %q %q", resp.StatusCode(), resp.Header.Peek("Location"), resp.Body())
+	}
+	if values := resp.Header.PeekAll("X-Multi"); len(values) != 2 {
+		t.Errorf("X-Multi has %d values, want 2", len(values))
+	}
+}
+
+func TestCachedResponseRefresh(t *testing.T) {
+	cached := &CachedResponse{
+		StatusCode: 200,
+		Headers: []CachedHeader{
+			{Key: "Content-Type", Value: "application/json"},
+			{Key: "ETag", Value: `"v1"`},
+			{Key: "X-Kept", Value: "yes"},
+		},
+		StoredAt: time.Now().Add(-time.Hour),
+	}
+
+	var notModified fasthttp.Response
+	notModified.SetStatusCode(fasthttp.StatusNotModified)
+	notModified.Header.Set("ETag", `"v2"`)
+	notModified.Header.Set("Cache-Control", "max-age=60")
+	cached.Refresh(&notModified.Header)
+
+	want := map[string]string{"Content-Type": "application/json", "ETag": `"v2"`, "X-Kept": "yes", "Cache-Control": "max-age=60"}
+	for name, value := range want {
+		if got := cached.Header(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if cached.Age() > time.Second {
+		t.Errorf("Age = %s after refresh, want it restarted", cached.Age())
+	}
+	if !cached.HasValidators() {
+		t.Error("HasValidators = false with an ETag")
+	}
+}
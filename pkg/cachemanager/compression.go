@@ -0,0 +1,73 @@
+package cachemanager
+
+import (
+	"fmt"
+	"hermyx/pkg/utils/compress"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Compress compresses the body with the given encoding before it is stored.
+// Bodies below minSize, bodies the upstream already encoded and bodies that
+// would not shrink are left as they are.
+func (r *CachedResponse) Compress(encoding string, minSize int) error {
+	if r.BodyEncoding != "" || r.IsVaryMarker() || len(r.Body) < minSize {
+		return nil
+	}
+	if contentEncoding := r.Header(fasthttp.HeaderContentEncoding); contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+		return nil
+	}
+
+	compressed, err := compress.Encode(encoding, r.Body)
+	if err != nil {
+		return err
+	}
+	if len(compressed) >= len(r.Body) {
+		return nil
+	}
+
+	r.Body = compressed
+	r.BodyEncoding = encoding
+	return nil
+}
+
+// writeEncodedBody serves a body compressed at rest. Either way the response
+// now depends on Accept-Encoding, which Vary has to tell downstream caches.
+func (r *CachedResponse) writeEncodedBody(resp *fasthttp.Response, acceptEncoding []byte) error {
+	AddVary(&resp.Header, fasthttp.HeaderAcceptEncoding)
+
+	if compress.Accepts(acceptEncoding, r.BodyEncoding) {
+		resp.Header.Set(fasthttp.HeaderContentEncoding, r.BodyEncoding)
+		WeakenETag(&resp.Header)
+		resp.SetBody(r.Body)
+		return nil
+	}
+
+	body, err := compress.Decode(r.BodyEncoding, r.Body)
+	if err != nil {
+		return fmt.Errorf("%w: undecodable %s body: %v", ErrInvalidCachedResponse, r.BodyEncoding, err)
+	}
+	resp.SetBody(body)
+	return nil
+}
+
+// AddVary lists the request header in Vary unless it is already there.
+func AddVary(header *fasthttp.ResponseHeader, name string) {
+	for _, value := range header.PeekAll(fasthttp.HeaderVary) {
+		for _, listed := range strings.Split(string(value), ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), name) {
+				return
+			}
+		}
+	}
+	header.Add(fasthttp.HeaderVary, name)
+}
+
+// WeakenETag marks a strong ETag as weak. Compressed bytes are a different
+// representation than the one a strong validator from the upstream names.
+func WeakenETag(header *fasthttp.ResponseHeader) {
+	if etag := header.Peek(fasthttp.HeaderETag); len(etag) > 0 && !strings.HasPrefix(string(etag), "W/") {
+		header.Set(fasthttp.HeaderETag, "W/"+string(etag))
+	}
+}
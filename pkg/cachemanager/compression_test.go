@@ -0,0 +1,125 @@
+package cachemanager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"hermyx/pkg/utils/compress"
+	"slices"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCompress(t *testing.T) {
+	compressible := bytes.Repeat([]byte("hermyx "), 500)
+	random := make([]byte, 4096)
+	rand.Read(random)
+
+	tests := []struct {
+		name     string
+		response *CachedResponse
+		minSize  int
+		want     string
+	}{
+		{name: "compressible", response: &CachedResponse{Body: compressible}, minSize: 1024, want: compress.ZSTD},
+		{name: "below min size", response: &CachedResponse{Body: compressible}, minSize: len(compressible) + 1},
+		{name: "already encoded upstream", response: &CachedResponse{Body: compressible, Headers: []CachedHeader{{Key: "Content-Encoding", Value: "gzip"}}}},
+		{name: "identity encoding", response: &CachedResponse{Body: compressible, Headers: []CachedHeader{{Key: "Content-Encoding", Value: "identity"}}}, want: compress.ZSTD},
+		{name: "does not shrink", response: &CachedResponse{Body: random}},
+		{name: "vary marker", response: NewVaryMarker([]string{"accept-language"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]byte(nil), tt.response.Body...)
+			if err := tt.response.Compress(compress.ZSTD, tt.minSize); err != nil {
+				t.Fatal(err)
+			}
+			if tt.response.BodyEncoding != tt.want {
+				t.Fatalf("BodyEncoding = %q, want %q", tt.response.BodyEncoding, tt.want)
+			}
+			if tt.want == "" && !bytes.Equal(tt.response.Body, original) {
+				t.Error("body changed without being compressed")
+			}
+		})
+	}
+}
+
+func TestWriteToServesCompressedBody(t *testing.T) {
+	body := bytes.Repeat([]byte("hermyx "), 500)
+	stored := &CachedResponse{StatusCode: 200, Body: body, Headers: []CachedHeader{{Key: "ETag", Value: `"v1"`}}}
+	if err := stored.Compress(compress.GZIP, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		etag           string
+	}{
+		{acceptEncoding: "gzip, br", encoding: "gzip", etag: `W/"v1"`},
+		{acceptEncoding: "br", etag: `"v1"`},
+		{acceptEncoding: "", etag: `"v1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			var resp fasthttp.Response
+			if err := stored.WriteTo(&resp, []byte(tt.acceptEncoding)); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(resp.Header.Peek("Content-Encoding")); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := string(resp.Header.Peek("ETag")); got != tt.etag {
+				t.Errorf("ETag = %q, want %q", got, tt.etag)
+			}
+			if got := string(resp.Header.Peek("Vary")); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			decoded, err := resp.BodyUncompressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, body) {
+				t.Errorf("served %d bytes, want the original %d", len(decoded), len(body))
+			}
+		})
+	}
+}
+
+func TestWriteToRejectsUndecodableBody(t *testing.T) {
+	stored := &CachedResponse{StatusCode: 200, Body: []byte("garbage"), BodyEncoding: compress.ZSTD}
+
+	var resp fasthttp.Response
+	if err := stored.WriteTo(&resp, nil); err == nil {
+		t.Error("WriteTo served a body that does not decode")
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		existing []string
+		want     []string
+	}{
+		{want: []string{"Accept-Encoding"}},
+		{existing: []string{"Accept-Language"}, want: []string{"Accept-Language", "Accept-Encoding"}},
+		{existing: []string{"accept-language, accept-encoding"}, want: []string{"accept-language, accept-encoding"}},
+	}
+
+	for _, tt := range tests {
+		var header fasthttp.ResponseHeader
+		for _, value := range tt.existing {
+			header.Add("Vary", value)
+		}
+		AddVary(&header, "Accept-Encoding")
+
+		var got []string
+		for _, value := range header.PeekAll("Vary") {
+			got = append(got, string(value))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Vary %v after AddVary, want %v", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package cachemanager
+
+import (
+	"bytes"
+	"hermyx/pkg/utils/hash"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// GenerateETag derives a strong validator from the response body.
+func GenerateETag(body []byte) string {
+	return `"` + hash.HashBytes(body)[:32] + `"`
+}
+
+// NotModified evaluates the request's If-None-Match and If-Modified-Since
+// preconditions against the stored validators (RFC 9110 §13.2.2). It
+// returns true when the client's copy is still current and a 304 can be
+// sent instead of the body. Only a 2xx entry is a representation the
+// preconditions can refer to; a cached redirect or error is always replayed.
+func (r *CachedResponse) NotModified(header *fasthttp.RequestHeader) bool {
+	if !header.IsGet() && !header.IsHead() {
+		return false
+	}
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return false
+	}
+
+	if ifNoneMatch := header.Peek(fasthttp.HeaderIfNoneMatch); len(ifNoneMatch) > 0 {
+		etag := r.Header(fasthttp.HeaderETag)
+		if etag == "" {
+			return false
+		}
+		return etagListMatches(ifNoneMatch, etag)
+	}
+
+	ifModifiedSince := header.Peek(fasthttp.HeaderIfModifiedSince)
+	if len(ifModifiedSince) == 0 {
+		return false
+	}
+	since, err := fasthttp.ParseHTTPDate(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	lastModified, err := fasthttp.ParseHTTPDate([]byte(r.Header(fasthttp.HeaderLastModified)))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(since)
+}
+
+// etagListMatches performs the weak comparison If-None-Match requires.
+func etagListMatches(list []byte, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range bytes.Split(list, []byte(",")) {
+		candidate = bytes.TrimSpace(candidate)
+		if string(candidate) == "*" {
+			return true
+		}
+		if string(bytes.TrimPrefix(candidate, []byte("W/"))) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteNotModifiedTo replays the stored headers with a 304 status and no body.
+func (r *CachedResponse) WriteNotModifiedTo(resp *fasthttp.Response) {
+	resp.SetStatusCode(fasthttp.StatusNotModified)
+	for _, header := range r.Headers {
+		resp.Header.Add(header.Key, header.Value)
+	}
+	resp.ResetBody()
+	resp.SkipBody = true
+}
@@ -0,0 +1,81 @@
+package cachemanager
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestGenerateETag(t *testing.T) {
+	etag := GenerateETag([]byte("body"))
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) != 34 {
+		t.Errorf("GenerateETag = %s, want a quoted 32-character tag", etag)
+	}
+	if etag != GenerateETag([]byte("body")) || etag == GenerateETag([]byte("other")) {
+		t.Error("GenerateETag is not a function of the body")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	entry := func(status int, headers ...CachedHeader) *CachedResponse {
+		return &CachedResponse{StatusCode: status, Headers: headers}
+	}
+	withETag := entry(200, CachedHeader{Key: "ETag", Value: `"v1"`})
+	withWeakETag := entry(200, CachedHeader{Key: "ETag", Value: `W/"v1"`})
+	withDate := entry(200, CachedHeader{Key: "Last-Modified", Value: lastModified})
+
+	tests := []struct {
+		name    string
+		entry   *CachedResponse
+		method  string
+		request map[string]string
+		want    bool
+	}{
+		{name: "no preconditions", entry: withETag},
+		{name: "matching etag", entry: withETag, request: map[string]string{"If-None-Match": `"v1"`}, want: true},
+		{name: "etag in list", entry: withETag, request: map[string]string{"If-None-Match": `"v0", "v1"`}, want: true},
+		{name: "weak comparison", entry: withWeakETag, request: map[string]string{"If-None-Match": `"v1"`}, want: true},
+		{name: "weak candidate", entry: withETag, request: map[string]string{"If-None-Match": `W/"v1"`}, want: true},
+		{name: "star", entry: withETag, request: map[string]string{"If-None-Match": "*"}, want: true},
+		{name: "other etag", entry: withETag, request: map[string]string{"If-None-Match": `"v2"`}},
+		{name: "entry without etag", entry: withDate, request: map[string]string{"If-None-Match": `"v1"`}},
+		{name: "if-none-match wins", entry: entry(200, CachedHeader{Key: "ETag", Value: `"v1"`}, CachedHeader{Key: "Last-Modified", Value: lastModified}), request: map[string]string{"If-None-Match": `"v2"`, "If-Modified-Since": lastModified}},
+		{name: "not modified since", entry: withDate, request: map[string]string{"If-Modified-Since": lastModified}, want: true},
+		{name: "later date", entry: withDate, request: map[string]string{"If-Modified-Since": "Thu, 22 Oct 2015 07:28:00 GMT"}, want: true},
+		{name: "modified since", entry: withDate, request: map[string]string{"If-Modified-Since": "Tue, 20 Oct 2015 07:28:00 GMT"}},
+		{name: "invalid date", entry: withDate, request: map[string]string{"If-Modified-Since": "yesterday"}},
+		{name: "head", entry: withETag, method: "HEAD", request: map[string]string{"If-None-Match": `"v1"`}, want: true},
+		{name: "post", entry: withETag, method: "POST", request: map[string]string{"If-None-Match": `"v1"`}},
+		{name: "cached not found", entry: entry(404, CachedHeader{Key: "ETag", Value: `"v1"`}), request: map[string]string{"If-None-Match": `"v1"`}},
+		{name: "cached redirect", entry: entry(301, CachedHeader{Key: "Last-Modified", Value: lastModified}), request: map[string]string{"If-Modified-Since": lastModified}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header fasthttp.RequestHeader
+			header.SetMethod("GET")
+			if tt.method != "" {
+				header.SetMethod(tt.method)
+			}
+			for name, value := range tt.request {
+				header.Set(name, value)
+			}
+
+			if got := tt.entry.NotModified(&header); got != tt.want {
+				t.Errorf("NotModified = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteNotModifiedTo(t *testing.T) {
+	entry := &CachedResponse{StatusCode: 200, Headers: []CachedHeader{{Key: "ETag", Value: `"v1"`}}, Body: []byte("body")}
+
+	var resp fasthttp.Response
+	entry.WriteNotModifiedTo(&resp)
+	if resp.StatusCode() != fasthttp.StatusNotModified || len(resp.Body()) != 0 || string(resp.Header.Peek("ETag")) != `"v1"` {
+		t.Errorf("got %d %q with ETag %q", resp.StatusCode(), resp.Body(), resp.Header.Peek("ETag"))
+	}
+}
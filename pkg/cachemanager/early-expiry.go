@@ -0,0 +1,31 @@
+package cachemanager
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// jitterTtl shortens ttl by a random fraction of up to jitter. Shortening
+// rather than lengthening keeps an entry from outliving the freshness the
+// upstream granted it.
+func jitterTtl(ttl time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(float64(ttl)*min(jitter, 1)*rand.Float64())
+}
+
+// RefreshEarly decides whether a fresh hit should refresh the entry ahead
+// of its expiry, following the XFetch algorithm: the closer the entry is to
+// ExpiresAt and the longer its last fetch took, the likelier a refresh.
+// beta scales how early refreshes start; 1 is the usual choice.
+func (r *CachedResponse) RefreshEarly(beta float64) bool {
+	if beta <= 0 || r.FetchDuration <= 0 || r.ExpiresAt.IsZero() {
+		return false
+	}
+
+	// 1-Float64 lies in (0, 1], which keeps the logarithm finite.
+	lead := time.Duration(float64(r.FetchDuration) * beta * -math.Log(1-rand.Float64()))
+	return !time.Now().Add(lead).Before(r.ExpiresAt)
+}
@@ -0,0 +1,109 @@
+package cachemanager
+
+import (
+	"hermyx/pkg/cache"
+	"hermyx/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestJitterTtl(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		jitter   float64
+		min, max time.Duration
+	}{
+		{name: "no jitter", ttl: time.Minute, jitter: 0, min: time.Minute, max: time.Minute},
+		{name: "negative jitter", ttl: time.Minute, jitter: -0.5, min: time.Minute, max: time.Minute},
+		{name: "no ttl", ttl: 0, jitter: 0.5, min: 0, max: 0},
+		{name: "ten percent", ttl: time.Minute, jitter: 0.1, min: 54 * time.Second, max: time.Minute},
+		{name: "clamped to the whole ttl", ttl: time.Minute, jitter: 3, min: 0, max: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[time.Duration]bool)
+			for i := 0; i < 1000; i++ {
+				got := jitterTtl(tt.ttl, tt.jitter)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("jitterTtl(%s, %v) = %s, want within [%s, %s]", tt.ttl, tt.jitter, got, tt.min, tt.max)
+				}
+				seen[got] = true
+			}
+			if tt.min != tt.max && len(seen) < 2 {
+				t.Errorf("jitterTtl(%s, %v) never varied", tt.ttl, tt.jitter)
+			}
+		})
+	}
+}
+
+func TestSetAppliesJitter(t *testing.T) {
+	manager := NewCacheManager(func(name string, config *models.CacheConfig) (ICache, error) {
+		return cache.NewCache(100, 0), nil
+	})
+	manager.Backend(DefaultBackend, nil)
+
+	before := time.Now()
+	response := &CachedResponse{StatusCode: 200}
+	if err := manager.Set(DefaultBackend, "api|a", response, time.Hour, 0, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := response.ExpiresAt.Sub(before); ttl < 30*time.Minute || ttl > time.Hour+time.Second {
+		t.Errorf("jittered entry expires in %s, want between 30m and 1h", ttl)
+	}
+}
+
+func TestRefreshEarly(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		response CachedResponse
+		beta     float64
+		// bounds of the refreshes out of 1000 hits
+		atLeast, atMost int
+	}{
+		{name: "turned off", response: CachedResponse{FetchDuration: time.Second, ExpiresAt: now.Add(time.Millisecond)}, beta: 0, atLeast: 0, atMost: 0},
+		{name: "no fetch duration", response: CachedResponse{ExpiresAt: now.Add(time.Millisecond)}, beta: 1, atLeast: 0, atMost: 0},
+		{name: "no expiry", response: CachedResponse{FetchDuration: time.Second}, beta: 1, atLeast: 0, atMost: 0},
+		{name: "far from expiry", response: CachedResponse{FetchDuration: time.Millisecond, ExpiresAt: now.Add(time.Hour)}, beta: 1, atLeast: 0, atMost: 0},
+		{name: "already expired", response: CachedResponse{FetchDuration: time.Millisecond, ExpiresAt: now.Add(-time.Second)}, beta: 1, atLeast: 1000, atMost: 1000},
+		{name: "slow fetch close to expiry", response: CachedResponse{FetchDuration: time.Second, ExpiresAt: now.Add(10 * time.Millisecond)}, beta: 1, atLeast: 900, atMost: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refreshes := 0
+			for i := 0; i < 1000; i++ {
+				if tt.response.RefreshEarly(tt.beta) {
+					refreshes++
+				}
+			}
+
+			if refreshes < tt.atLeast || refreshes > tt.atMost {
+				t.Errorf("refreshed %d of 1000 times, want between %d and %d", refreshes, tt.atLeast, tt.atMost)
+			}
+		})
+	}
+}
+
+func TestRefreshEarlyGrowsWithBeta(t *testing.T) {
+	response := CachedResponse{FetchDuration: 100 * time.Millisecond, ExpiresAt: time.Now().Add(time.Second)}
+
+	count := func(beta float64) int {
+		refreshes := 0
+		for i := 0; i < 2000; i++ {
+			if response.RefreshEarly(beta) {
+				refreshes++
+			}
+		}
+		return refreshes
+	}
+
+	// P(refresh) = exp(-gap / (fetch * beta)): about 0.00005 for beta 1 and
+	// 0.37 for beta 10.
+	if low, high := count(1), count(10); low >= high {
+		t.Errorf("beta 1 refreshed %d times and beta 10 %d times, want more with the larger beta", low, high)
+	}
+}
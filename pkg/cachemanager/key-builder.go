@@ -0,0 +1,199 @@
+package cachemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hermyx/pkg/models"
+	"hermyx/pkg/utils/compress"
+	"hermyx/pkg/utils/hash"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	KEY_HASH_XXHASH = "xxhash"
+	KEY_HASH_SHA256 = "sha256"
+)
+
+// Every generation bump of a route is recorded as a meta value of the
+// route's backend, so that a restart does not bring back entries the bump
+// invalidated. Meta values are not entries: no eviction or purge drops them.
+const generationMetaPrefix = "generation|"
+
+// KeyBuilder builds the cache keys of one route. A key reads
+//
+//	<route>|g<generation>|<material>
+//
+// where the material holds the parts the key config lists, joined with "|",
+// or their hash. The route prefix keeps routes that share a backend apart
+// and lets a route be purged as a whole; bumping the generation moves every
+// request of the route to new keys at once.
+type KeyBuilder struct {
+	route       string
+	pathPattern *regexp.Regexp
+	config      *models.CacheKeyConfig
+	query       *queryFilter
+
+	// bumps counts the generation bumps on top of the configured generation.
+	bumps  atomic.Uint64
+	bumpMu sync.Mutex
+}
+
+// NewKeyBuilder compiles the key config of a route. pathPattern is the
+// route's path regex, whose named groups the param part reads.
+func NewKeyBuilder(route string, pathPattern *regexp.Regexp, config *models.CacheKeyConfig) (*KeyBuilder, error) {
+	builder := &KeyBuilder{route: route, pathPattern: pathPattern, config: config}
+
+	switch config.Hash {
+	case "", KEY_HASH_XXHASH, KEY_HASH_SHA256:
+	default:
+		return nil, fmt.Errorf("unknown key hash %q; use %q or %q", config.Hash, KEY_HASH_XXHASH, KEY_HASH_SHA256)
+	}
+
+	for _, name := range config.Params {
+		if pathPattern == nil || pathPattern.SubexpIndex(name) < 0 {
+			return nil, fmt.Errorf("path parameter %q is not a named group of the route path", name)
+		}
+	}
+
+	if config.Query != nil {
+		query, err := compileQueryFilter(config.Query)
+		if err != nil {
+			return nil, err
+		}
+		builder.query = query
+	}
+
+	return builder, nil
+}
+
+func (kb *KeyBuilder) Generation() uint64 {
+	return kb.config.Generation + kb.bumps.Load()
+}
+
+// Build returns the key of the request together with its readable form,
+// which differs from the key only when the material is hashed.
+func (kb *KeyBuilder) Build(ctx *fasthttp.RequestCtx) (string, string) {
+	prefix := kb.route + "|g" + strconv.FormatUint(kb.Generation(), 10) + "|"
+	material := kb.material(ctx)
+	return prefix + kb.hash(material), prefix + material
+}
+
+// VariantKey derives the key of the variant stored under key for the
+// request's values of the Vary headers. Like the material of the key, the
+// header values are hashed when the key config asks for it.
+func (kb *KeyBuilder) VariantKey(key string, vary []string, header *fasthttp.RequestHeader) string {
+	return variantPrefix(key) + kb.hash(variantMaterial(vary, header))
+}
+
+// hash returns the configured hash of material, or material itself.
+func (kb *KeyBuilder) hash(material string) string {
+	switch kb.config.Hash {
+	case KEY_HASH_XXHASH:
+		return strconv.FormatUint(xxhash.Sum64String(material), 16)
+	case KEY_HASH_SHA256:
+		sum := sha256.Sum256([]byte(material))
+		return hex.EncodeToString(sum[:])
+	}
+	return material
+}
+
+func (kb *KeyBuilder) material(ctx *fasthttp.RequestCtx) string {
+	var keyParts []string
+
+	for _, keyType := range kb.config.Type {
+		switch keyType {
+		case models.CACHE_KEY_METHOD:
+			keyParts = append(keyParts, strings.ToLower(string(ctx.Method())))
+		case models.CACHE_KEY_PATH:
+			keyParts = append(keyParts, string(ctx.Path()))
+		case models.CACHE_KEY_QUERY:
+			if kb.query != nil {
+				keyParts = append(keyParts, kb.query.normalize(ctx.QueryArgs()))
+			} else {
+				keyParts = append(keyParts, string(ctx.QueryArgs().QueryString()))
+			}
+		case models.CACHE_KEY_ENCODING:
+			keyParts = append(keyParts, compress.Negotiate(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)))
+		case models.CACHE_KEY_HEADER:
+			for _, header := range kb.config.Headers {
+				if header == nil {
+					continue
+				}
+
+				keyPart := string(ctx.Request.Header.Peek(header.Key))
+				if len(keyPart) != 0 {
+					keyParts = append(keyParts, keyPart)
+				}
+			}
+		case models.CACHE_KEY_COOKIE:
+			// Cookie values are usually credentials, so only their hash ends
+			// up in keys and logs. A missing cookie keys differently from
+			// every present one.
+			for _, name := range kb.config.Cookies {
+				keyPart := name + "="
+				if value := ctx.Request.Header.Cookie(name); len(value) != 0 {
+					keyPart += hash.HashBytes(value)
+				}
+				keyParts = append(keyParts, keyPart)
+			}
+		case models.CACHE_KEY_PARAM:
+			match := kb.pathPattern.FindStringSubmatch(string(ctx.Path()))
+			for _, name := range kb.config.Params {
+				keyPart := name + "="
+				if index := kb.pathPattern.SubexpIndex(name); index < len(match) {
+					keyPart += match[index]
+				}
+				keyParts = append(keyParts, keyPart)
+			}
+		case models.CACHE_KEY_BODY:
+			keyParts = append(keyParts, "body="+hash.HashBytes(ctx.Request.Body()))
+		}
+	}
+
+	return strings.Join(keyParts, "|")
+}
+
+// LoadGeneration restores the generation bumps recorded for the route in
+// the backend.
+func (cm *CacheManager) LoadGeneration(backend string, kb *KeyBuilder) error {
+	cache, err := cm.lookup(backend)
+	if err != nil {
+		return err
+	}
+
+	value, exists, err := cache.GetMeta(generationMetaPrefix + kb.route)
+	if err != nil || !exists {
+		return err
+	}
+
+	bumps, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid generation record for route %s: %w", kb.route, err)
+	}
+	kb.bumps.Store(bumps)
+	return nil
+}
+
+// BumpGeneration moves the route to a new generation and records it in the
+// backend. It returns the new generation.
+func (cm *CacheManager) BumpGeneration(backend string, kb *KeyBuilder) (uint64, error) {
+	cache, err := cm.lookup(backend)
+	if err != nil {
+		return kb.Generation(), err
+	}
+
+	kb.bumpMu.Lock()
+	defer kb.bumpMu.Unlock()
+
+	bumps := kb.bumps.Add(1)
+	record := []byte(strconv.FormatUint(bumps, 10))
+	return kb.Generation(), cache.SetMeta(generationMetaPrefix+kb.route, record)
+}
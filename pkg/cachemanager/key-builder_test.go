@@ -0,0 +1,234 @@
+package cachemanager
+
+import (
+	"hermyx/pkg/cache"
+	"hermyx/pkg/models"
+	"hermyx/pkg/utils/hash"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func newRequestCtx(method, uri string, headers map[string]string, body string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	for key, value := range headers {
+		ctx.Request.Header.Set(key, value)
+	}
+	ctx.Request.SetBodyString(body)
+	return ctx
+}
+
+func TestNewKeyBuilder(t *testing.T) {
+	path := regexp.MustCompile(`^/users/(?P<id>\d+)`)
+
+	tests := []struct {
+		name    string
+		config  *models.CacheKeyConfig
+		wantErr string
+	}{
+		{name: "default", config: &models.CacheKeyConfig{}},
+		{name: "known hash", config: &models.CacheKeyConfig{Hash: KEY_HASH_SHA256}},
+		{name: "unknown hash", config: &models.CacheKeyConfig{Hash: "md5"}, wantErr: "unknown key hash"},
+		{name: "named param", config: &models.CacheKeyConfig{Params: []string{"id"}}},
+		{name: "unknown param", config: &models.CacheKeyConfig{Params: []string{"name"}}, wantErr: `path parameter "name"`},
+		{name: "invalid query pattern", config: &models.CacheKeyConfig{Query: &models.QueryKeyConfig{Include: []string{"("}}}, wantErr: "invalid query include pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyBuilder("api", path, tt.config)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyBuilderBuild(t *testing.T) {
+	path := regexp.MustCompile(`^/users/(?P<id>\d+)(?:/(?P<tab>\w+))?`)
+
+	tests := []struct {
+		name    string
+		config  *models.CacheKeyConfig
+		ctx     *fasthttp.RequestCtx
+		want    string
+		hashed  bool
+		wantLen int // of a hashed key, 0 = any
+	}{
+		{
+			name:   "method path query",
+			config: &models.CacheKeyConfig{Type: []string{"method", "path", "query"}},
+			ctx:    newRequestCtx("GET", "/users/1?b=2&a=1", nil, ""),
+			want:   "api|g0|get|/users/1|b=2&a=1",
+		},
+		{
+			name:   "generation",
+			config: &models.CacheKeyConfig{Type: []string{"path"}, Generation: 3},
+			ctx:    newRequestCtx("GET", "/users/1", nil, ""),
+			want:   "api|g3|/users/1",
+		},
+		{
+			name:   "headers skip missing values",
+			config: &models.CacheKeyConfig{Type: []string{"header"}, Headers: []*models.HeaderCacheKeyConfig{{Key: "X-Tenant"}, {Key: "X-Missing"}, nil}},
+			ctx:    newRequestCtx("GET", "/", map[string]string{"X-Tenant": "acme"}, ""),
+			want:   "api|g0|acme",
+		},
+		{
+			name:   "cookies are hashed",
+			config: &models.CacheKeyConfig{Type: []string{"cookie"}, Cookies: []string{"session", "theme"}},
+			ctx:    newRequestCtx("GET", "/", map[string]string{"Cookie": "session=secret; other=x"}, ""),
+			want:   "api|g0|session=" + hash.HashBytes([]byte("secret")) + "|theme=",
+		},
+		{
+			name:   "params",
+			config: &models.CacheKeyConfig{Type: []string{"param"}, Params: []string{"id", "tab"}},
+			ctx:    newRequestCtx("GET", "/users/42/posts", nil, ""),
+			want:   "api|g0|id=42|tab=posts",
+		},
+		{
+			name:   "unmatched optional param",
+			config: &models.CacheKeyConfig{Type: []string{"param"}, Params: []string{"id", "tab"}},
+			ctx:    newRequestCtx("GET", "/users/42", nil, ""),
+			want:   "api|g0|id=42|tab=",
+		},
+		{
+			name:   "body",
+			config: &models.CacheKeyConfig{Type: []string{"method", "body"}},
+			ctx:    newRequestCtx("POST", "/search", nil, `{"q":"hermyx"}`),
+			want:   "api|g0|post|body=" + hash.HashBytes([]byte(`{"q":"hermyx"}`)),
+		},
+		{
+			name:   "xxhash",
+			config: &models.CacheKeyConfig{Type: []string{"path"}, Hash: KEY_HASH_XXHASH},
+			ctx:    newRequestCtx("GET", "/users/1", nil, ""),
+			want:   "api|g0|/users/1",
+			hashed: true,
+		},
+		{
+			name:    "sha256",
+			config:  &models.CacheKeyConfig{Type: []string{"path"}, Hash: KEY_HASH_SHA256},
+			ctx:     newRequestCtx("GET", "/users/1", nil, ""),
+			want:    "api|g0|/users/1",
+			hashed:  true,
+			wantLen: len("api|g0|") + 64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, err := NewKeyBuilder("api", path, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, readable := kb.Build(tt.ctx)
+			if readable != tt.want {
+				t.Errorf("readable key = %q, want %q", readable, tt.want)
+			}
+			if !tt.hashed {
+				if key != readable {
+					t.Errorf("key = %q, want the readable key", key)
+				}
+				return
+			}
+			if !strings.HasPrefix(key, "api|g0|") || key == readable {
+				t.Errorf("key = %q, want the route prefix and a hashed material", key)
+			}
+			if tt.wantLen != 0 && len(key) != tt.wantLen {
+				t.Errorf("len(key) = %d, want %d", len(key), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestKeyBuilderSeparatesRequests(t *testing.T) {
+	config := &models.CacheKeyConfig{Type: []string{"cookie", "body"}, Cookies: []string{"session"}}
+	kb, err := NewKeyBuilder("api", nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(cookie, body string) string {
+		key, _ := kb.Build(newRequestCtx("POST", "/", map[string]string{"Cookie": cookie}, body))
+		return key
+	}
+
+	if build("session=a", "x") == build("session=b", "x") {
+		t.Error("different cookies share a key")
+	}
+	if build("session=a", "x") == build("session=a", "y") {
+		t.Error("different bodies share a key")
+	}
+	if build("", "x") == build("session=a", "x") {
+		t.Error("a missing cookie shares a key with a present one")
+	}
+}
+
+func TestKeyBuilderVariantKey(t *testing.T) {
+	var header fasthttp.RequestHeader
+	header.Set("Accept-Language", " en ")
+	vary := []string{"accept-language", "x-user"}
+
+	plain, _ := NewKeyBuilder("api", nil, &models.CacheKeyConfig{})
+	if got, want := plain.VariantKey("api|g0|/x", vary, &header), "api|g0|/x|vary|accept-language=en|x-user="; got != want {
+		t.Errorf("VariantKey without hash = %q, want %q", got, want)
+	}
+
+	header.Set("X-User", "alice@example.com")
+
+	hashed, _ := NewKeyBuilder("api", nil, &models.CacheKeyConfig{Hash: KEY_HASH_SHA256})
+	got := hashed.VariantKey("api|g0|abc", vary, &header)
+	if !strings.HasPrefix(got, "api|g0|abc|vary|") || strings.Contains(got, "alice") {
+		t.Errorf("VariantKey with hash = %q, want the variant prefix and hashed header values", got)
+	}
+	if len(got) != len("api|g0|abc|vary|")+64 {
+		t.Errorf("VariantKey with hash = %q, want a sha256 suffix", got)
+	}
+}
+
+func TestGenerationSurvivesPurge(t *testing.T) {
+	dir := t.TempDir()
+	newManager := func() *CacheManager {
+		manager := NewCacheManager(func(name string, config *models.CacheConfig) (ICache, error) {
+			return cache.NewDiskCache(dir, 10)
+		})
+		if _, err := manager.Backend(DefaultBackend, nil); err != nil {
+			t.Fatal(err)
+		}
+		return manager
+	}
+	newBuilder := func() *KeyBuilder {
+		kb, err := NewKeyBuilder("api", nil, &models.CacheKeyConfig{Generation: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kb
+	}
+
+	manager := newManager()
+	kb := newBuilder()
+	if err := manager.LoadGeneration(DefaultBackend, kb); err != nil {
+		t.Fatalf("LoadGeneration without a record: %v", err)
+	}
+	if generation, err := manager.BumpGeneration(DefaultBackend, kb); err != nil || generation != 2 {
+		t.Fatalf("BumpGeneration = %d, %v, want 2", generation, err)
+	}
+	if _, err := manager.Purge("*"); err != nil {
+		t.Fatal(err)
+	}
+	manager.Close()
+
+	manager = newManager()
+	defer manager.Close()
+	kb = newBuilder()
+	if err := manager.LoadGeneration(DefaultBackend, kb); err != nil || kb.Generation() != 2 {
+		t.Errorf("reloaded generation = %d, %v, want 2", kb.Generation(), err)
+	}
+}
@@ -0,0 +1,174 @@
+package cachemanager
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// OriginPolicy is what the upstream's Cache-Control, Expires and Vary
+// headers allow a shared cache to do with a response (RFC 9111).
+type OriginPolicy struct {
+	Storable bool
+
+	// HasFreshness is true when the origin supplied an explicit lifetime
+	// through s-maxage, max-age or Expires. Ttl is only meaningful then.
+	HasFreshness bool
+	Ttl          time.Duration
+
+	// Vary lists the lowercased request headers that select a variant.
+	Vary []string
+}
+
+// ParseCacheControl splits a Cache-Control header into lowercased directive
+// names and their (unquoted) values.
+func ParseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
+
+// ParseOriginPolicy reads the policy of a response to a request with the
+// given headers.
+func ParseOriginPolicy(reqHeader *fasthttp.RequestHeader, header *fasthttp.ResponseHeader, now time.Time) OriginPolicy {
+	policy := OriginPolicy{Storable: true}
+
+	directives := ParseCacheControl(string(header.Peek(fasthttp.HeaderCacheControl)))
+	if _, ok := directives["no-store"]; ok {
+		policy.Storable = false
+		return policy
+	}
+	if _, ok := directives["private"]; ok {
+		policy.Storable = false
+		return policy
+	}
+
+	// A response to an authenticated request is only shared when the
+	// origin explicitly allows it (RFC 9111 §3.5).
+	if len(reqHeader.Peek(fasthttp.HeaderAuthorization)) > 0 && !sharedDespiteAuthorization(directives) {
+		policy.Storable = false
+		return policy
+	}
+
+	for _, name := range strings.Split(string(header.Peek(fasthttp.HeaderVary)), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "*" {
+			policy.Storable = false
+			return policy
+		}
+		policy.Vary = append(policy.Vary, name)
+	}
+	sort.Strings(policy.Vary)
+
+	if _, ok := directives["no-cache"]; ok {
+		// Storable, but must be revalidated before every reuse.
+		policy.HasFreshness = true
+		return policy
+	}
+
+	// The lifetime the origin grants is counted from when it generated the
+	// response; caches in front of it report the time since in Age.
+	age := upstreamAge(header)
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if arg, ok := directives[directive]; ok {
+			seconds, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || seconds < 0 {
+				seconds = 0
+			}
+			policy.HasFreshness = true
+			policy.Ttl = max(time.Duration(seconds)*time.Second-age, 0)
+			return policy
+		}
+	}
+
+	if expires := header.Peek(fasthttp.HeaderExpires); len(expires) > 0 {
+		policy.HasFreshness = true
+
+		// An invalid Expires value (commonly "0") means already expired.
+		expiresAt, err := fasthttp.ParseHTTPDate(expires)
+		if err != nil {
+			return policy
+		}
+
+		base := now
+		if date, err := fasthttp.ParseHTTPDate(header.Peek(fasthttp.HeaderDate)); err == nil {
+			base = date
+		}
+		if ttl := expiresAt.Sub(base) - age; ttl > 0 {
+			policy.Ttl = ttl
+		}
+	}
+
+	return policy
+}
+
+func sharedDespiteAuthorization(directives map[string]string) bool {
+	for _, directive := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[directive]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamAge reads the Age header of a response; a missing or invalid one
+// counts as zero.
+func upstreamAge(header *fasthttp.ResponseHeader) time.Duration {
+	seconds, err := strconv.ParseInt(string(header.Peek(fasthttp.HeaderAge)), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ForbidsStale reports whether the origin's Cache-Control forbids serving
+// the response once stale without revalidating it first: must-revalidate,
+// and for a shared cache proxy-revalidate and s-maxage (RFC 9111 §5.2.2),
+// as well as no-cache, which requires revalidation before every reuse.
+func (r *CachedResponse) ForbidsStale() bool {
+	directives := ParseCacheControl(r.Header(fasthttp.HeaderCacheControl))
+	for _, directive := range []string{"must-revalidate", "proxy-revalidate", "s-maxage", "no-cache"} {
+		if _, ok := directives[directive]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// variantPrefix is what the keys of every variant stored under key start
+// with.
+func variantPrefix(key string) string {
+	return key + "|vary|"
+}
+
+func variantMaterial(vary []string, header *fasthttp.RequestHeader) string {
+	parts := make([]string, 0, len(vary))
+	for _, name := range vary {
+		parts = append(parts, name+"="+strings.TrimSpace(string(header.Peek(name))))
+	}
+	return strings.Join(parts, "|")
+}
+
+// ParseSurrogateKeys splits a Surrogate-Key header into its space-separated
+// tags.
+func ParseSurrogateKeys(value string) []string {
+	tags := strings.Fields(value)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
@@ -0,0 +1,93 @@
+package cachemanager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	got := ParseCacheControl(`Public, MAX-AGE=60, s-maxage="120", , no-cache="Set-Cookie"`)
+	want := map[string]string{"public": "", "max-age": "60", "s-maxage": "120", "no-cache": "Set-Cookie"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCacheControl = %v, want %v", got, want)
+	}
+}
+
+func TestParseOriginPolicy(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(time.RFC1123)
+	inAnHour := now.Add(time.Hour).Format(time.RFC1123)
+
+	tests := []struct {
+		name         string
+		request      map[string]string
+		response     map[string]string
+		storable     bool
+		hasFreshness bool
+		ttl          time.Duration
+		vary         []string
+		forbidsStale bool
+	}{
+		{name: "no headers", storable: true},
+		{name: "no-store", response: map[string]string{"Cache-Control": "no-store, max-age=60"}},
+		{name: "private", response: map[string]string{"Cache-Control": "private"}},
+		{name: "max-age", response: map[string]string{"Cache-Control": "max-age=60"}, storable: true, hasFreshness: true, ttl: time.Minute},
+		{name: "s-maxage wins", response: map[string]string{"Cache-Control": "max-age=60, s-maxage=120"}, storable: true, hasFreshness: true, ttl: 2 * time.Minute, forbidsStale: true},
+		{name: "invalid max-age", response: map[string]string{"Cache-Control": "max-age=soon"}, storable: true, hasFreshness: true},
+		{name: "max-age less age", response: map[string]string{"Cache-Control": "max-age=60", "Age": "45"}, storable: true, hasFreshness: true, ttl: 15 * time.Second},
+		{name: "age past max-age", response: map[string]string{"Cache-Control": "max-age=60", "Age": "90"}, storable: true, hasFreshness: true},
+		{name: "invalid age", response: map[string]string{"Cache-Control": "max-age=60", "Age": "old"}, storable: true, hasFreshness: true, ttl: time.Minute},
+		{name: "expires", response: map[string]string{"Date": date, "Expires": inAnHour}, storable: true, hasFreshness: true, ttl: time.Hour},
+		{name: "expires less age", response: map[string]string{"Date": date, "Expires": inAnHour, "Age": "600"}, storable: true, hasFreshness: true, ttl: 50 * time.Minute},
+		{name: "invalid expires", response: map[string]string{"Expires": "0"}, storable: true, hasFreshness: true},
+		{name: "no-cache", response: map[string]string{"Cache-Control": "no-cache, max-age=60"}, storable: true, hasFreshness: true, forbidsStale: true},
+		{name: "must-revalidate", response: map[string]string{"Cache-Control": "max-age=60, must-revalidate"}, storable: true, hasFreshness: true, ttl: time.Minute, forbidsStale: true},
+		{name: "proxy-revalidate", response: map[string]string{"Cache-Control": "max-age=60, proxy-revalidate"}, storable: true, hasFreshness: true, ttl: time.Minute, forbidsStale: true},
+		{name: "vary", response: map[string]string{"Vary": "X-User, Accept-Language"}, storable: true, vary: []string{"accept-language", "x-user"}},
+		{name: "vary star", response: map[string]string{"Vary": "Accept, *"}},
+		{name: "authorization", request: map[string]string{"Authorization": "Bearer t"}, response: map[string]string{"Cache-Control": "max-age=60"}},
+		{name: "authorization public", request: map[string]string{"Authorization": "Bearer t"}, response: map[string]string{"Cache-Control": "public, max-age=60"}, storable: true, hasFreshness: true, ttl: time.Minute},
+		{name: "authorization s-maxage", request: map[string]string{"Authorization": "Bearer t"}, response: map[string]string{"Cache-Control": "s-maxage=60"}, storable: true, hasFreshness: true, ttl: time.Minute, forbidsStale: true},
+		{name: "authorization must-revalidate", request: map[string]string{"Authorization": "Bearer t"}, response: map[string]string{"Cache-Control": "must-revalidate, max-age=60"}, storable: true, hasFreshness: true, ttl: time.Minute, forbidsStale: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqHeader fasthttp.RequestHeader
+			for name, value := range tt.request {
+				reqHeader.Set(name, value)
+			}
+			var resp fasthttp.Response
+			for name, value := range tt.response {
+				resp.Header.Set(name, value)
+			}
+
+			policy := ParseOriginPolicy(&reqHeader, &resp.Header, now)
+			if policy.Storable != tt.storable {
+				t.Fatalf("Storable = %v, want %v", policy.Storable, tt.storable)
+			}
+			if !tt.storable {
+				return
+			}
+			if policy.HasFreshness != tt.hasFreshness || policy.Ttl != tt.ttl {
+				t.Errorf("freshness = %v %s, want %v %s", policy.HasFreshness, policy.Ttl, tt.hasFreshness, tt.ttl)
+			}
+			if !reflect.DeepEqual(policy.Vary, tt.vary) {
+				t.Errorf("Vary = %v, want %v", policy.Vary, tt.vary)
+			}
+			if forbids := NewCachedResponse(&resp).ForbidsStale(); forbids != tt.forbidsStale {
+				t.Errorf("ForbidsStale = %v, want %v", forbids, tt.forbidsStale)
+			}
+		})
+	}
+}
+
+func TestParseSurrogateKeys(t *testing.T) {
+	got := ParseSurrogateKeys("  b a  b c ")
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSurrogateKeys = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package cachemanager
+
+import (
+	"fmt"
+	"hermyx/pkg/models"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// queryFilter is the compiled form of a QueryKeyConfig. Include and exclude
+// entries match whole parameter names, so a plain name matches only itself.
+type queryFilter struct {
+	config  *models.QueryKeyConfig
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func compileQueryFilter(config *models.QueryKeyConfig) (*queryFilter, error) {
+	filter := &queryFilter{config: config}
+
+	var err error
+	if len(config.Include) > 0 {
+		if filter.include, err = compileNamePatterns(config.Include); err != nil {
+			return nil, fmt.Errorf("invalid query include pattern: %w", err)
+		}
+	}
+	if len(config.Exclude) > 0 {
+		if filter.exclude, err = compileNamePatterns(config.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid query exclude pattern: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+func compileNamePatterns(patterns []string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:(?:" + strings.Join(patterns, ")|(?:") + "))$")
+}
+
+type queryParam struct {
+	key   string
+	value string
+}
+
+// normalize rebuilds the query string of args with the filter applied, so
+// that requests differing only in parameter order, tracking parameters or
+// value case share a key.
+func (filter *queryFilter) normalize(args *fasthttp.Args) string {
+	var params []queryParam
+	args.VisitAll(func(key, value []byte) {
+		param := queryParam{key: string(key), value: string(value)}
+		if filter.include != nil && !filter.include.MatchString(param.key) {
+			return
+		}
+		if filter.exclude != nil && filter.exclude.MatchString(param.key) {
+			return
+		}
+		if filter.config.DropEmpty && param.value == "" {
+			return
+		}
+		if filter.config.LowercaseValues {
+			param.value = strings.ToLower(param.value)
+		}
+		params = append(params, param)
+	})
+
+	if filter.config.Sort {
+		sort.SliceStable(params, func(i, j int) bool {
+			if params[i].key != params[j].key {
+				return params[i].key < params[j].key
+			}
+			return params[i].value < params[j].value
+		})
+	}
+
+	var normalized fasthttp.Args
+	for _, param := range params {
+		normalized.Add(param.key, param.value)
+	}
+	return string(normalized.QueryString())
+}
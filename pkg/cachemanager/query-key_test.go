@@ -0,0 +1,56 @@
+package cachemanager
+
+import (
+	"hermyx/pkg/models"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestQueryFilterNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.QueryKeyConfig
+		query  string
+		want   string
+	}{
+		{name: "unchanged", query: "b=2&a=1", want: "b=2&a=1"},
+		{name: "sort", config: models.QueryKeyConfig{Sort: true}, query: "b=2&a=1&c=3", want: "a=1&b=2&c=3"},
+		{name: "sort repeated names by value", config: models.QueryKeyConfig{Sort: true}, query: "a=2&b=1&a=1", want: "a=1&a=2&b=1"},
+		{name: "include", config: models.QueryKeyConfig{Include: []string{"id", "page"}}, query: "utm_source=x&id=1&page=2&idx=3", want: "id=1&page=2"},
+		{name: "include pattern", config: models.QueryKeyConfig{Include: []string{"f_.*"}}, query: "f_a=1&g=2&f_b=3", want: "f_a=1&f_b=3"},
+		{name: "exclude", config: models.QueryKeyConfig{Exclude: []string{"utm_.*", "fbclid"}}, query: "utm_source=x&id=1&fbclid=y", want: "id=1"},
+		{name: "exclude wins over include", config: models.QueryKeyConfig{Include: []string{".*"}, Exclude: []string{"session"}}, query: "session=1&id=2", want: "id=2"},
+		{name: "lowercase values", config: models.QueryKeyConfig{LowercaseValues: true}, query: "Q=Hermyx", want: "Q=hermyx"},
+		{name: "drop empty", config: models.QueryKeyConfig{DropEmpty: true}, query: "a=&b=1&c", want: "b=1"},
+		{name: "keep empty", query: "a=&b=1", want: "a=&b=1"},
+		{name: "encoded values", config: models.QueryKeyConfig{Sort: true}, query: "q=a%20b&p=%2F", want: "p=%2F&q=a+b"},
+		{name: "everything filtered", config: models.QueryKeyConfig{Include: []string{"id"}}, query: "a=1", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := compileQueryFilter(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var args fasthttp.Args
+			args.Parse(tt.query)
+			if got := filter.normalize(&args); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileQueryFilterRejectsInvalidPatterns(t *testing.T) {
+	for _, config := range []*models.QueryKeyConfig{
+		{Include: []string{"["}},
+		{Exclude: []string{"a(b"}},
+	} {
+		if _, err := compileQueryFilter(config); err == nil {
+			t.Errorf("compileQueryFilter(%+v) accepted an invalid pattern", config)
+		}
+	}
+}
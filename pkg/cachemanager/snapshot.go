@@ -0,0 +1,149 @@
+package cachemanager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A snapshot is a portable copy of the live entries of every backend. It
+// starts with a magic and a version byte, followed by one record per entry:
+//
+//	[backend len uint16][backend][key len uint32][key]
+//	[ttl int64 nanoseconds, 0 = no expiry][value len uint32][value]
+//
+// and ends with a record whose backend length is 0xFFFF. Values are the
+// encoded CachedResponse envelopes exactly as the backends store them. On
+// import, entries with no expiry take the TTL of the backend they land in.
+const (
+	snapshotMagic   = "HMXSNAP"
+	snapshotVersion = 1
+	snapshotEnd     = 0xFFFF
+)
+
+var ErrInvalidSnapshot = errors.New("invalid cache snapshot")
+
+type SnapshotEntry struct {
+	Backend string
+	Key     string
+	Value   []byte
+	Ttl     time.Duration
+}
+
+type SnapshotWriter struct {
+	w *bufio.Writer
+}
+
+func NewSnapshotWriter(w io.Writer) (*SnapshotWriter, error) {
+	writer := &SnapshotWriter{w: bufio.NewWriter(w)}
+	if _, err := writer.w.WriteString(snapshotMagic); err != nil {
+		return nil, err
+	}
+	if err := writer.w.WriteByte(snapshotVersion); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (sw *SnapshotWriter) Write(entry SnapshotEntry) error {
+	if len(entry.Backend) >= snapshotEnd {
+		return fmt.Errorf("backend name %q is too long for a snapshot", entry.Backend)
+	}
+
+	buf := make([]byte, 0, 2+len(entry.Backend)+4+len(entry.Key)+8+4)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(entry.Backend)))
+	buf = append(buf, entry.Backend...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Key)))
+	buf = append(buf, entry.Key...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.Ttl))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Value)))
+
+	if _, err := sw.w.Write(buf); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(entry.Value)
+	return err
+}
+
+// Close writes the end marker and flushes the snapshot. It does not close
+// the underlying writer.
+func (sw *SnapshotWriter) Close() error {
+	if err := binary.Write(sw.w, binary.BigEndian, uint16(snapshotEnd)); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// ReadSnapshot calls fn with every entry of the snapshot read from r. A
+// snapshot without its end marker is reported as truncated.
+func ReadSnapshot(r io.Reader, fn func(SnapshotEntry) error) error {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+
+	for {
+		var backendLen uint16
+		if err := binary.Read(reader, binary.BigEndian, &backendLen); err != nil {
+			return fmt.Errorf("%w: truncated data", ErrInvalidSnapshot)
+		}
+		if backendLen == snapshotEnd {
+			return nil
+		}
+
+		entry := SnapshotEntry{}
+		backend := make([]byte, backendLen)
+		var keyLen uint32
+		var ttl int64
+		var valueLen uint32
+
+		if _, err := io.ReadFull(reader, backend); err != nil {
+			return fmt.Errorf("%w: truncated data", ErrInvalidSnapshot)
+		}
+		if err := binary.Read(reader, binary.BigEndian, &keyLen); err != nil {
+			return fmt.Errorf("%w: truncated data", ErrInvalidSnapshot)
+		}
+		key, err := readSnapshotBytes(reader, keyLen)
+		if err != nil {
+			return err
+		}
+		if err := binary.Read(reader, binary.BigEndian, &ttl); err != nil {
+			return fmt.Errorf("%w: truncated data", ErrInvalidSnapshot)
+		}
+		if err := binary.Read(reader, binary.BigEndian, &valueLen); err != nil {
+			return fmt.Errorf("%w: truncated data", ErrInvalidSnapshot)
+		}
+		value, err := readSnapshotBytes(reader, valueLen)
+		if err != nil {
+			return err
+		}
+
+		entry.Backend = string(backend)
+		entry.Key = string(key)
+		entry.Value = value
+		entry.Ttl = time.Duration(ttl)
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// readSnapshotBytes reads a length-prefixed field. The buffer grows as data
+// arrives rather than being sized from the length up front, so a corrupt
+// length cannot allocate gigabytes.
+func readSnapshotBytes(reader io.Reader, length uint32) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, int64(length)))
+	if err != nil || len(data) != int(length) {
+		return nil, fmt.Errorf("%w: truncated data", ErrInvalidSnapshot)
+	}
+	return data, nil
+}
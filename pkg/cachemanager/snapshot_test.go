@@ -0,0 +1,184 @@
+package cachemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hermyx/pkg/cache"
+	"hermyx/pkg/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeSnapshot(t *testing.T, entries ...SnapshotEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer, err := NewSnapshotWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := writer.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(data []byte) ([]SnapshotEntry, error) {
+	var entries []SnapshotEntry
+	err := ReadSnapshot(bytes.NewReader(data), func(entry SnapshotEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []SnapshotEntry
+	}{
+		{name: "empty"},
+		{name: "entries", entries: []SnapshotEntry{
+			{Backend: "default", Key: "api|GET|/a", Value: []byte("envelope"), Ttl: time.Minute},
+			{Backend: "images", Key: "images|GET|/b", Value: []byte{}, Ttl: 0},
+			{Backend: "", Key: "", Value: bytes.Repeat([]byte{0xFF}, 1<<16), Ttl: time.Nanosecond},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(writeSnapshot(t, tt.entries...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.entries) {
+				t.Errorf("read back %d entries that differ from the %d written", len(got), len(tt.entries))
+			}
+		})
+	}
+}
+
+func TestReadSnapshotRejectsInvalidData(t *testing.T) {
+	valid := writeSnapshot(t, SnapshotEntry{Backend: "default", Key: "key", Value: []byte("value"), Ttl: time.Minute})
+
+	tests := map[string][]byte{
+		"empty":       nil,
+		"wrong magic": append([]byte("NOTSNAP"), valid[7:]...),
+		"new version": append(append([]byte(snapshotMagic), snapshotVersion+1), valid[8:]...),
+		// A value length of 4 GiB with no data behind it.
+		"huge length": append(valid[:len(valid)-7], 0xFF, 0xFF, 0xFF, 0xFF),
+	}
+	// Every cut before the end marker leaves the snapshot truncated.
+	for cut := 0; cut < len(valid)-1; cut++ {
+		tests[fmt.Sprintf("cut at %d", cut)] = valid[:cut]
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readAll(data); !errors.Is(err, ErrInvalidSnapshot) {
+				t.Errorf("err = %v, want ErrInvalidSnapshot", err)
+			}
+		})
+	}
+}
+
+func TestReadSnapshotStopsOnCallbackError(t *testing.T) {
+	data := writeSnapshot(t, SnapshotEntry{Key: "a"}, SnapshotEntry{Key: "b"})
+	stop := errors.New("stop")
+
+	calls := 0
+	err := ReadSnapshot(bytes.NewReader(data), func(SnapshotEntry) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the callback error after 1", err, calls)
+	}
+}
+
+func TestSnapshotWriterRejectsLongBackendName(t *testing.T) {
+	writer, _ := NewSnapshotWriter(&bytes.Buffer{})
+	if err := writer.Write(SnapshotEntry{Backend: strings.Repeat("b", snapshotEnd)}); err == nil {
+		t.Error("Write accepted a backend name as long as the end marker")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	newManager := func() *CacheManager {
+		manager := NewCacheManager(func(name string, config *models.CacheConfig) (ICache, error) {
+			return cache.NewCache(100, 0), nil
+		})
+		manager.Backend(DefaultBackend, nil)
+		manager.Backend("images", nil)
+		return manager
+	}
+
+	source := newManager()
+	tagged := &CachedResponse{StatusCode: 200, Body: []byte("a"), Tags: []string{"product-1"}}
+	source.Set(DefaultBackend, "api|a", tagged, time.Minute, 0, 0)
+	source.Set("images", "images|b", &CachedResponse{StatusCode: 200, Body: []byte("b")}, time.Hour, 0, 0)
+
+	var buf bytes.Buffer
+	writer, _ := NewSnapshotWriter(&buf)
+	if exported, err := source.Export(writer); err != nil || exported != 2 {
+		t.Fatalf("Export = %d, %v", exported, err)
+	}
+	writer.Close()
+
+	target := newManager()
+	if imported, err := target.Import(&buf); err != nil || imported != 2 {
+		t.Fatalf("Import = %d, %v", imported, err)
+	}
+
+	for backend, key := range map[string]string{DefaultBackend: "api|a", "images": "images|b"} {
+		if _, ok, err := target.Get(backend, key); !ok || err != nil {
+			t.Errorf("%s lost key %s: %v", backend, key, err)
+		}
+	}
+	if purged, _ := target.PurgeTag("product-1"); purged != 1 {
+		t.Errorf("PurgeTag after import removed %d entries, want the tagged one", purged)
+	}
+}
+
+func TestImportGivesEntriesWithoutExpiryTheBackendTtl(t *testing.T) {
+	var buf bytes.Buffer
+	writer, _ := NewSnapshotWriter(&buf)
+	value := (&CachedResponse{StatusCode: 200, Body: []byte("a")}).Encode()
+	writer.Write(SnapshotEntry{Backend: DefaultBackend, Key: "api|a", Value: value})
+	writer.Write(SnapshotEntry{Backend: "images", Key: "images|b", Value: value})
+	writer.Close()
+
+	memory := cache.NewCache(100, 0)
+	manager := NewCacheManager(func(name string, config *models.CacheConfig) (ICache, error) {
+		if name == DefaultBackend {
+			return memory, nil
+		}
+		return cache.NewCache(100, 0), nil
+	})
+	manager.Backend(DefaultBackend, &models.CacheConfig{Ttl: time.Minute})
+	manager.Backend("images", &models.CacheConfig{})
+
+	if imported, err := manager.Import(&buf); err != nil || imported != 1 {
+		t.Fatalf("Import = %d, %v, want only the entry whose backend has a TTL", imported, err)
+	}
+
+	var ttl time.Duration
+	memory.Enumerate(func(key string, value []byte, remaining time.Duration) error {
+		ttl = remaining
+		return nil
+	})
+	if ttl <= 50*time.Second || ttl > time.Minute {
+		t.Errorf("imported entry has TTL %v, want the backend's 1m", ttl)
+	}
+	if _, ok, _ := manager.Get("images", "images|b"); ok {
+		t.Error("entry without expiry was imported into a backend without a TTL")
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermyx/pkg/cachemanager"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+type SnapshotOptions struct {
+	// File is the snapshot to write or read.
+	File string
+
+	// Admin is the base URL of a running instance's admin API. When set,
+	// the snapshot is exported from or imported into that instance;
+	// otherwise the backends of the config are opened directly.
+	Admin string
+
+	// Token authenticates against the admin API. It defaults to the
+	// HERMYX_ADMIN_TOKEN environment variable.
+	Token string
+}
+
+var errMemoryCacheOffline = errors.New("a memory cache only lives inside the running instance; use --admin")
+
+// openDirect builds an engine whose backends the snapshot reads or writes
+// directly. It refuses while an instance owns them, or when a caching
+// route keeps its entries in memory.
+func openDirect(configPath string) (*HermyxEngine, error) {
+	if pid, running := runningInstance(configPath); running {
+		return nil, fmt.Errorf("hermyx is running with PID %d and owns the cache; use --admin", pid)
+	}
+
+	engine := InstantiateHermyxEngine(configPath)
+	if names := engine.memoryBackends(); len(names) > 0 {
+		engine.cacheManager.Close()
+		engine.logger.Close()
+		return nil, fmt.Errorf("%w (backends %s)", errMemoryCacheOffline, strings.Join(names, ", "))
+	}
+	return engine, nil
+}
+
+func ExportCache(configPath string, options SnapshotOptions) (int, error) {
+	if options.Admin != "" {
+		file, err := createSnapshotFile(options.File)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		return exportViaAdmin(file, options)
+	}
+
+	engine, err := openDirect(configPath)
+	if err != nil {
+		return 0, err
+	}
+	defer engine.logger.Close()
+	defer engine.cacheManager.Close()
+
+	file, err := createSnapshotFile(options.File)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	writer, err := cachemanager.NewSnapshotWriter(file)
+	if err != nil {
+		return 0, err
+	}
+	exported, err := engine.cacheManager.Export(writer)
+	if err != nil {
+		return exported, err
+	}
+	return exported, writer.Close()
+}
+
+func createSnapshotFile(path string) (*os.File, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	return file, nil
+}
+
+func ImportCache(configPath string, options SnapshotOptions) (int, error) {
+	file, err := os.Open(options.File)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer file.Close()
+
+	if options.Admin != "" {
+		return importViaAdmin(file, options)
+	}
+
+	engine, err := openDirect(configPath)
+	if err != nil {
+		return 0, err
+	}
+	defer engine.logger.Close()
+	defer engine.cacheManager.Close()
+
+	return engine.cacheManager.Import(file)
+}
+
+func exportViaAdmin(file *os.File, options SnapshotOptions) (int, error) {
+	req, resp := adminRequest(fasthttp.MethodGet, "/cache/export", options)
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	if err := fasthttp.Do(req, resp); err != nil {
+		return 0, fmt.Errorf("failed to reach the admin API: %w", err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return 0, fmt.Errorf("admin API answered %d: %s", resp.StatusCode(), resp.Body())
+	}
+
+	if _, err := file.Write(resp.Body()); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	// Reading the snapshot back counts its entries and catches an export
+	// that was cut short.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	exported := 0
+	err := cachemanager.ReadSnapshot(file, func(cachemanager.SnapshotEntry) error {
+		exported++
+		return nil
+	})
+	return exported, err
+}
+
+func importViaAdmin(file *os.File, options SnapshotOptions) (int, error) {
+	body, err := io.ReadAll(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+
+	req, resp := adminRequest(fasthttp.MethodPost, "/cache/import", options)
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetBody(body)
+
+	if err := fasthttp.Do(req, resp); err != nil {
+		return 0, fmt.Errorf("failed to reach the admin API: %w", err)
+	}
+
+	var result importResult
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return 0, fmt.Errorf("admin API answered %d: %s", resp.StatusCode(), resp.Body())
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return result.Imported, fmt.Errorf("admin API answered %d: %s", resp.StatusCode(), result.Error)
+	}
+	return result.Imported, nil
+}
+
+func adminRequest(method, path string, options SnapshotOptions) (*fasthttp.Request, *fasthttp.Response) {
+	token := options.Token
+	if token == "" {
+		token = os.Getenv("HERMYX_ADMIN_TOKEN")
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod(method)
+	req.SetRequestURI(strings.TrimSuffix(options.Admin, "/") + path)
+	req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+
+	return req, fasthttp.AcquireResponse()
+}
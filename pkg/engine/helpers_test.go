@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// testConfig is a config with one caching route in front of UPSTREAM.
+// STORAGE is replaced by a temporary directory.
+const testConfig = `
+log: {toStdout: false}
+server: {port: 1}
+storage: {path: STORAGE}
+cache:
+  type: memory
+  enabled: true
+  ttl: 1m
+  keyConfig: {type: [method, path, query]}
+routes:
+  - name: api
+    path: "^/"
+    target: UPSTREAM
+    cache: {enabled: true}
+`
+
+type testProxy struct {
+	engine   *HermyxEngine
+	upstream *httptest.Server
+	url      string
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// newTestProxy starts an upstream serving handler and a Hermyx engine,
+// configured by config, listening in front of it.
+func newTestProxy(t *testing.T, config string, handler http.HandlerFunc) *testProxy {
+	t.Helper()
+
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	engine := InstantiateHermyxEngine(writeTestConfig(t, config, upstream))
+	t.Cleanup(func() { engine.cacheManager.Close() })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fasthttp.Server{Handler: engine.handleRequest}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown() })
+
+	return &testProxy{engine: engine, upstream: upstream, url: "http://" + listener.Addr().String()}
+}
+
+// writeTestConfig fills in UPSTREAM and STORAGE and writes the config to a
+// temporary directory, which also serves as the storage path.
+func writeTestConfig(t *testing.T, config string, upstream *httptest.Server) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	config = strings.ReplaceAll(config, "UPSTREAM", upstream.Listener.Addr().String())
+	config = strings.ReplaceAll(config, "STORAGE", dir)
+	configPath := filepath.Join(dir, "hermyx.config.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func (p *testProxy) do(t *testing.T, method, path string, headers map[string]string) testResponse {
+	t.Helper()
+	return p.send(t, method, path, headers, "")
+}
+
+// send is do with a request body.
+func (p *testProxy) send(t *testing.T, method, path string, headers map[string]string, body string) testResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(method, p.url+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{
+		Transport:     &http.Transport{DisableCompression: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testResponse{status: resp.StatusCode, header: resp.Header, body: string(respBody)}
+}
+
+func (p *testProxy) get(t *testing.T, path string) testResponse {
+	t.Helper()
+	return p.do(t, http.MethodGet, path, nil)
+}
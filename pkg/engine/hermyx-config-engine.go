@@ -10,10 +10,15 @@ import (
 	"hermyx/pkg/utils/logger"
 	"hermyx/pkg/utils/system"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/valyala/fasthttp"
 	"gopkg.in/yaml.v3"
@@ -24,6 +29,8 @@ type compiledRoute struct {
 	PathPattern  *regexp.Regexp
 	IncludeRegex *regexp.Regexp
 	ExcludeRegex *regexp.Regexp
+	Backend      string
+	Keys         *cachemanager.KeyBuilder
 }
 
 type HermyxEngine struct {
@@ -33,7 +40,16 @@ type HermyxEngine struct {
 	compiledRoutes []compiledRoute
 	configPath     string
 	pid            uint64
-	hostClients    map[string]*fasthttp.HostClient
+	streamClients  map[string]*fasthttp.HostClient
+	clientsMu      sync.Mutex
+	refreshing     sync.Map
+	refreshes      sync.WaitGroup
+	inflight       map[string]*inflightFetch
+	inflightMu     sync.Mutex
+	purgeNets      []*net.IPNet
+	bans           []banRule
+	bansMu         sync.RWMutex
+	maxLifetime    atomic.Int64
 }
 
 func InstantiateHermyxEngine(configPath string) *HermyxEngine {
@@ -99,7 +115,7 @@ func InstantiateHermyxEngine(configPath string) *HermyxEngine {
 		storageDir := filepath.Join(programDataDir, hash.HashString(absConfigPath))
 		logger_.Info(fmt.Sprintf("Assigning storage path as %s", storageDir))
 
-		config.Storage = &models.StorageConfig{storageDir}
+		config.Storage = &models.StorageConfig{Path: storageDir}
 	}
 	if config.Routes == nil {
 		config.Routes = []models.RouteConfig{}
@@ -112,54 +128,133 @@ func InstantiateHermyxEngine(configPath string) *HermyxEngine {
 		}
 	}
 
-	var cache_ cachemanager.ICache
-
-	switch config.Cache.Type {
-	case models.CACHE_TYPE_MEMORY:
-		cache_ = cache.NewCache(config.Cache.Capacity)
-
-	case models.CACHE_TYPE_DISK:
-		diskCache, err := cache.NewDiskCache(config.Storage.Path, config.Cache.Capacity)
-		if err != nil {
-			log.Fatalf("Unable to instantiate the disk-cache: %v", err)
+	if config.Admin != nil {
+		if config.Admin.Token == "" {
+			config.Admin.Token = os.Getenv("HERMYX_ADMIN_TOKEN")
 		}
-		cache_ = diskCache
-	case models.CACHE_TYPE_REDIS:
-		if config.Cache.Redis == nil {
-			log.Fatalf("Redis config hasn't been provided.")
+		if config.Admin.Token == "" {
+			log.Fatalf("The admin API needs a token; set admin.token or HERMYX_ADMIN_TOKEN")
+		}
+		if config.Admin.Port == 0 {
+			log.Fatalf("The admin API needs a port; set admin.port")
+		}
+		if config.Server != nil && config.Admin.Port == config.Server.Port {
+			log.Fatalf("The admin API port %d is already used by the proxy", config.Admin.Port)
 		}
-
-		cache_ = cache.NewRedisCache(config.Cache.Redis)
-
 	}
 
-	cacheManager := cachemanager.NewCacheManager(cache_)
+	cacheManager := cachemanager.NewCacheManager(newCacheBackend(config.Storage))
+	if _, err := cacheManager.Backend(cachemanager.DefaultBackend, config.Cache); err != nil {
+		log.Fatalf("Unable to instantiate the %s cache: %v", config.Cache.Type, err)
+	}
 
 	engine := &HermyxEngine{
-		config:       &config,
-		logger:       logger_,
-		cacheManager: cacheManager,
-		configPath:   configPath,
-		pid:          uint64(os.Getpid()),
-		hostClients:  make(map[string]*fasthttp.HostClient),
+		config:        &config,
+		logger:        logger_,
+		cacheManager:  cacheManager,
+		configPath:    configPath,
+		pid:           uint64(os.Getpid()),
+		streamClients: make(map[string]*fasthttp.HostClient),
+		inflight:      make(map[string]*inflightFetch),
 	}
 
 	engine.compileRoutes()
+	engine.compilePurgeConfig()
 
 	return engine
 }
 
-func (engine *HermyxEngine) getClientForTarget(target string) *fasthttp.HostClient {
+// memoryBackends lists the backends caching routes use that only live in
+// memory, and so cannot be reached from outside the running instance.
+func (engine *HermyxEngine) memoryBackends() []string {
+	var names []string
+	for _, cr := range engine.compiledRoutes {
+		if cr.Route.Cache == nil || !cr.Route.Cache.Enabled || cr.Route.Cache.Type != models.CACHE_TYPE_MEMORY {
+			continue
+		}
+		if !slices.Contains(names, cr.Backend) {
+			names = append(names, cr.Backend)
+		}
+	}
+	return names
+}
+
+// targetAddr reduces a route target to the host and port its HostClients
+// dial, dropping the scheme and any path.
+func targetAddr(target string) string {
 	addr := strings.TrimPrefix(strings.TrimPrefix(target, "http://"), "https://")
+	addr, _, _ = strings.Cut(addr, "/")
+	return addr
+}
 
-	if client, ok := engine.hostClients[addr]; ok {
-		return client
-	}
+const defaultL1Ttl = time.Minute
+
+// newCacheBackend builds the cache backends the CacheManager asks for. Disk
+// backends other than the default one keep their file in a directory named
+// after the backend, and the default Redis backend keeps its keys beside
+// those of the route backends rather than above them.
+func newCacheBackend(storage *models.StorageConfig) cachemanager.BackendFactory {
+	return func(name string, config *models.CacheConfig) (cachemanager.ICache, error) {
+		switch config.Type {
+		case models.CACHE_TYPE_MEMORY:
+			return cache.NewCache(config.Capacity, config.MaxBytes), nil
+
+		case models.CACHE_TYPE_DISK:
+			storagePath := storage.Path
+			if name != cachemanager.DefaultBackend {
+				storagePath = filepath.Join(storage.Path, "caches", sanitizeName(name))
+			}
+			if err := fs.EnsureDir(storagePath); err != nil {
+				return nil, err
+			}
+			return cache.NewDiskCache(storagePath, config.Capacity)
+
+		case models.CACHE_TYPE_REDIS:
+			if config.Redis == nil {
+				return nil, fmt.Errorf("redis config hasn't been provided")
+			}
+			redisConfig := *config.Redis
+			if name == cachemanager.DefaultBackend {
+				redisConfig.KeyNamespace = cachemanager.RedisNamespace(redisConfig.KeyNamespace, name)
+			}
+			return cache.NewRedisCache(&redisConfig), nil
+
+		case models.CACHE_TYPE_TIERED:
+			tiered := config.Tiered
+			if tiered == nil {
+				return nil, fmt.Errorf("tiered config hasn't been provided")
+			}
+			if tiered.L2 != models.CACHE_TYPE_DISK && tiered.L2 != models.CACHE_TYPE_REDIS {
+				return nil, fmt.Errorf("tiered cache l2 must be %q or %q, got %q", models.CACHE_TYPE_DISK, models.CACHE_TYPE_REDIS, tiered.L2)
+			}
 
-	client := &fasthttp.HostClient{
-		Addr:     addr,
-		MaxConns: 10000, // Tune this
+			l2Config := *config
+			l2Config.Type = tiered.L2
+			l2, err := newCacheBackend(storage)(name, &l2Config)
+			if err != nil {
+				return nil, err
+			}
+
+			l1Capacity := tiered.L1Capacity
+			if l1Capacity == 0 {
+				l1Capacity = config.Capacity
+			}
+			l1Ttl := tiered.L1Ttl
+			if l1Ttl == 0 {
+				l1Ttl = defaultL1Ttl
+			}
+			return cache.NewTieredCache(cache.NewCache(l1Capacity, tiered.L1MaxBytes), l2.(cache.Tier), l1Ttl), nil
+		}
+
+		return nil, fmt.Errorf("unknown cache type %q", config.Type)
 	}
-	engine.hostClients[addr] = client
-	return client
+}
+
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
 }
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermyx/pkg/cachemanager"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultAdminHost = "127.0.0.1"
+	maxSnapshotSize  = 1 << 30
+)
+
+type purgeResult struct {
+	Purged int    `json:"purged"`
+	Error  string `json:"error,omitempty"`
+}
+
+// newAdminServer builds the admin listener. It is kept apart from the proxy
+// listener so it can be bound to a private interface.
+func (engine *HermyxEngine) newAdminServer() *fasthttp.Server {
+	return &fasthttp.Server{
+		Handler:          engine.handleAdminRequest,
+		DisableKeepalive: false,
+		// Snapshot imports carry the whole cache in the request body.
+		MaxRequestBodySize: maxSnapshotSize,
+	}
+}
+
+func (engine *HermyxEngine) adminAddr() string {
+	host := engine.config.Admin.Host
+	if host == "" {
+		host = defaultAdminHost
+	}
+	return fmt.Sprintf("%s:%d", host, engine.config.Admin.Port)
+}
+
+func (engine *HermyxEngine) handleAdminRequest(ctx *fasthttp.RequestCtx) {
+	if !engine.adminAuthorized(ctx) {
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, "Bearer")
+		writeAdminJSON(ctx, fasthttp.StatusUnauthorized, purgeResult{Error: "unauthorized"})
+		return
+	}
+
+	args := ctx.QueryArgs()
+	path := string(ctx.Path())
+
+	allowed := fasthttp.MethodPost
+	if path == "/cache/export" {
+		allowed = fasthttp.MethodGet
+	}
+	if string(ctx.Method()) != allowed {
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, allowed)
+		writeAdminJSON(ctx, fasthttp.StatusMethodNotAllowed, purgeResult{Error: "method not allowed"})
+		return
+	}
+
+	switch path {
+	case "/cache/export":
+		engine.handleAdminExport(ctx)
+		return
+	case "/cache/import":
+		engine.handleAdminImport(ctx)
+		return
+	case "/cache/generation":
+		engine.handleAdminGeneration(ctx)
+		return
+	}
+
+	var (
+		purged int
+		err    error
+	)
+
+	switch path {
+	case "/purge/key":
+		key := string(args.Peek("key"))
+		if key == "" {
+			writeAdminJSON(ctx, fasthttp.StatusBadRequest, purgeResult{Error: "missing key"})
+			return
+		}
+		purged, err = engine.cacheManager.PurgeKey(key)
+	case "/purge/prefix":
+		prefix := string(args.Peek("prefix"))
+		if prefix == "" {
+			writeAdminJSON(ctx, fasthttp.StatusBadRequest, purgeResult{Error: "missing prefix"})
+			return
+		}
+		purged, err = engine.cacheManager.PurgePrefix(prefix)
+	case "/purge/glob":
+		pattern := string(args.Peek("pattern"))
+		if pattern == "" {
+			writeAdminJSON(ctx, fasthttp.StatusBadRequest, purgeResult{Error: "missing pattern"})
+			return
+		}
+		purged, err = engine.cacheManager.Purge(pattern)
+	case "/purge/route":
+		name := string(args.Peek("route"))
+		if !engine.hasRoute(name) {
+			writeAdminJSON(ctx, fasthttp.StatusNotFound, purgeResult{Error: fmt.Sprintf("unknown route %q", name)})
+			return
+		}
+		purged, err = engine.cacheManager.PurgeRoute(name)
+	case "/purge/tag":
+		tag := string(args.Peek("tag"))
+		if tag == "" {
+			writeAdminJSON(ctx, fasthttp.StatusBadRequest, purgeResult{Error: "missing tag"})
+			return
+		}
+		purged, err = engine.cacheManager.PurgeTag(tag)
+	case "/purge/all":
+		purged, err = engine.cacheManager.Purge("*")
+	default:
+		writeAdminJSON(ctx, fasthttp.StatusNotFound, purgeResult{Error: "not found"})
+		return
+	}
+
+	if err != nil {
+		engine.logger.Error(fmt.Sprintf("Admin purge %s failed after %d entries: %v", path, purged, err))
+		writeAdminJSON(ctx, fasthttp.StatusInternalServerError, purgeResult{Purged: purged, Error: err.Error()})
+		return
+	}
+
+	engine.logger.Info(fmt.Sprintf("Admin purge %s?%s removed %d entries", path, args.String(), purged))
+	writeAdminJSON(ctx, fasthttp.StatusOK, purgeResult{Purged: purged})
+}
+
+type importResult struct {
+	Imported int    `json:"imported"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleAdminExport streams a snapshot of every backend. It is the only way
+// to export a memory cache, which lives inside the running instance.
+func (engine *HermyxEngine) handleAdminExport(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer, err := cachemanager.NewSnapshotWriter(w)
+		if err != nil {
+			engine.logger.Error(fmt.Sprintf("Admin export failed: %v", err))
+			return
+		}
+
+		exported, err := engine.cacheManager.Export(writer)
+		if err != nil {
+			// The missing end marker tells the client the snapshot is cut short.
+			engine.logger.Error(fmt.Sprintf("Admin export failed after %d entries: %v", exported, err))
+			return
+		}
+		if err := writer.Close(); err != nil {
+			engine.logger.Error(fmt.Sprintf("Admin export failed: %v", err))
+			return
+		}
+		engine.logger.Info(fmt.Sprintf("Admin export wrote %d entries", exported))
+	})
+}
+
+func (engine *HermyxEngine) handleAdminImport(ctx *fasthttp.RequestCtx) {
+	imported, err := engine.cacheManager.Import(bytes.NewReader(ctx.PostBody()))
+	if err != nil {
+		engine.logger.Error(fmt.Sprintf("Admin import failed after %d entries: %v", imported, err))
+		status := fasthttp.StatusInternalServerError
+		if errors.Is(err, cachemanager.ErrInvalidSnapshot) {
+			status = fasthttp.StatusBadRequest
+		}
+		writeAdminJSON(ctx, status, importResult{Imported: imported, Error: err.Error()})
+		return
+	}
+
+	engine.logger.Info(fmt.Sprintf("Admin import stored %d entries", imported))
+	writeAdminJSON(ctx, fasthttp.StatusOK, importResult{Imported: imported})
+}
+
+type generationResult struct {
+	Route      string `json:"route"`
+	Generation uint64 `json:"generation"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleAdminGeneration bumps the cache generation of a route, which moves
+// all of its requests to new keys. The old entries are left to expire.
+func (engine *HermyxEngine) handleAdminGeneration(ctx *fasthttp.RequestCtx) {
+	name := string(ctx.QueryArgs().Peek("route"))
+
+	var cr *compiledRoute
+	for i := range engine.compiledRoutes {
+		if name != "" && engine.compiledRoutes[i].Route.Name == name && engine.compiledRoutes[i].Keys != nil {
+			cr = &engine.compiledRoutes[i]
+			break
+		}
+	}
+	if cr == nil {
+		writeAdminJSON(ctx, fasthttp.StatusNotFound, generationResult{Route: name, Error: fmt.Sprintf("unknown route %q", name)})
+		return
+	}
+
+	generation, err := engine.cacheManager.BumpGeneration(cr.Backend, cr.Keys)
+	if err != nil {
+		// The bump holds until a restart even when it could not be recorded.
+		engine.logger.Error(fmt.Sprintf("Unable to record cache generation %d of route %s: %v", generation, name, err))
+		writeAdminJSON(ctx, fasthttp.StatusInternalServerError, generationResult{Route: name, Generation: generation, Error: err.Error()})
+		return
+	}
+
+	engine.logger.Info(fmt.Sprintf("Admin bumped the cache generation of route %s to %d", name, generation))
+	writeAdminJSON(ctx, fasthttp.StatusOK, generationResult{Route: name, Generation: generation})
+}
+
+// adminAuthorized checks the bearer token in constant time.
+func (engine *HermyxEngine) adminAuthorized(ctx *fasthttp.RequestCtx) bool {
+	token, ok := strings.CutPrefix(string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(engine.config.Admin.Token)) == 1
+}
+
+func (engine *HermyxEngine) hasRoute(name string) bool {
+	for _, cr := range engine.compiledRoutes {
+		if name != "" && cr.Route.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func writeAdminJSON(ctx *fasthttp.RequestCtx, status int, body any) {
+	data, _ := json.Marshal(body)
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+const adminConfig = testConfig + `admin: {port: 2, token: secret}
+`
+
+// admin sends a request straight to the admin handler and decodes its JSON
+// answer into result.
+func (p *testProxy) admin(t *testing.T, method, uri, token string, result any) int {
+	t.Helper()
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if token != "" {
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+	}
+	p.engine.handleAdminRequest(&ctx)
+
+	if result != nil {
+		if err := json.Unmarshal(ctx.Response.Body(), result); err != nil {
+			t.Fatalf("%s %s answered %q: %v", method, uri, ctx.Response.Body(), err)
+		}
+	}
+	return ctx.Response.StatusCode()
+}
+
+func TestAdminRejectsUnauthorizedRequests(t *testing.T) {
+	proxy := newTestProxy(t, adminConfig, func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, token := range []string{"", "wrong", "secre"} {
+		if status := proxy.admin(t, http.MethodPost, "/purge/all", token, nil); status != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d, want 401", token, status)
+		}
+	}
+	if status := proxy.admin(t, http.MethodGet, "/purge/all", "secret", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET /purge/all: status %d, want 405", status)
+	}
+}
+
+func TestAdminPurges(t *testing.T) {
+	config := strings.Replace(adminConfig, "query]}", "query], debugHeader: true}", 1)
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Surrogate-Key", "tag-"+strings.Split(r.URL.Path, "/")[1])
+		w.Write([]byte("body"))
+	})
+
+	paths := []string{"/a/1", "/a/2", "/b/1"}
+	keys := make(map[string]string)
+	fill := func(t *testing.T) {
+		for _, path := range paths {
+			keys[path] = proxy.get(t, path).header.Get("X-Hermyx-Cache-Key")
+		}
+	}
+	prefix := func(path string) string { return strings.TrimSuffix(keys[path], path+"|") }
+
+	tests := []struct {
+		name    string
+		uri     func() string
+		status  int
+		purged  int
+		dropped []string
+	}{
+		{name: "key", uri: func() string { return "/purge/key?key=" + url.QueryEscape(keys["/a/1"]) }, status: 200, purged: 1, dropped: []string{"/a/1"}},
+		{name: "prefix", uri: func() string { return "/purge/prefix?prefix=" + url.QueryEscape(prefix("/a/1")+"/a/") }, status: 200, purged: 2, dropped: []string{"/a/1", "/a/2"}},
+		{name: "glob", uri: func() string { return "/purge/glob?pattern=" + url.QueryEscape("*/b/*") }, status: 200, purged: 1, dropped: []string{"/b/1"}},
+		{name: "tag", uri: func() string { return "/purge/tag?tag=tag-a" }, status: 200, purged: 2, dropped: []string{"/a/1", "/a/2"}},
+		{name: "route", uri: func() string { return "/purge/route?route=api" }, status: 200, purged: 3, dropped: paths},
+		{name: "all", uri: func() string { return "/purge/all" }, status: 200, purged: 3, dropped: paths},
+		{name: "missing key", uri: func() string { return "/purge/key" }, status: 400},
+		{name: "unknown route", uri: func() string { return "/purge/route?route=nope" }, status: 404},
+		{name: "unknown action", uri: func() string { return "/purge/everything" }, status: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy.admin(t, http.MethodPost, "/purge/all", "secret", nil)
+			fill(t)
+
+			var result purgeResult
+			if status := proxy.admin(t, http.MethodPost, tt.uri(), "secret", &result); status != tt.status {
+				t.Fatalf("status %d (%s), want %d", status, result.Error, tt.status)
+			}
+			if result.Purged != tt.purged {
+				t.Errorf("purged %d, want %d", result.Purged, tt.purged)
+			}
+
+			for _, path := range paths {
+				want := "HIT"
+				if slices.Contains(tt.dropped, path) {
+					want = ""
+				}
+				if got := proxy.get(t, path).header.Get("X-Hermyx-Cache"); got != want {
+					t.Errorf("GET %s after the purge: X-Hermyx-Cache %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
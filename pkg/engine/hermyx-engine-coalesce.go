@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+	"hermyx/pkg/cachemanager"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const defaultCoalesceTimeout = 5 * time.Second
+
+// inflightFetch tracks the single upstream fetch that concurrent misses on
+// one cache key wait for.
+type inflightFetch struct {
+	done chan struct{}
+}
+
+// joinFetch registers interest in key. The first caller becomes the leader
+// and must call finishFetch once the response has been cached.
+func (engine *HermyxEngine) joinFetch(key string) (*inflightFetch, bool) {
+	engine.inflightMu.Lock()
+	defer engine.inflightMu.Unlock()
+
+	if fetch, ok := engine.inflight[key]; ok {
+		return fetch, false
+	}
+
+	fetch := &inflightFetch{done: make(chan struct{})}
+	engine.inflight[key] = fetch
+	return fetch, true
+}
+
+func (engine *HermyxEngine) finishFetch(key string, fetch *inflightFetch) {
+	engine.inflightMu.Lock()
+	delete(engine.inflight, key)
+	engine.inflightMu.Unlock()
+
+	close(fetch.done)
+}
+
+// awaitFetch waits for the leader's fetch and then answers from the cache it
+// filled. When the wait times out or the leader's response was not cached,
+// the caller falls back to its own upstream request with the returned stale
+// entry, which is the one it held before waiting unless the cache now has a
+// newer one.
+func (engine *HermyxEngine) awaitFetch(ctx *fasthttp.RequestCtx, cr *compiledRoute, key string, fetch *inflightFetch, stale *cachemanager.CachedResponse) (bool, *cachemanager.CachedResponse) {
+	timeout := cr.Route.Cache.CoalesceTimeout
+	if timeout <= 0 {
+		timeout = defaultCoalesceTimeout
+	}
+
+	engine.logger.Debug(fmt.Sprintf("Waiting on in-flight upstream fetch for key %s", key))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-fetch.done:
+	case <-timer.C:
+		engine.logger.Warn(fmt.Sprintf("Timed out after %s waiting on in-flight fetch for key %s; fetching directly", timeout, key))
+		return false, stale
+	}
+
+	hit, current := engine.handleCache(ctx, cr, key)
+	if !hit && current == nil {
+		current = stale
+	}
+	return hit, current
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentMissesShareOneFetch(t *testing.T) {
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, testConfig, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("body"))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := proxy.get(t, "/x"); resp.body != "body" {
+				t.Errorf("body = %q", resp.body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches.Load() != 1 {
+		t.Errorf("upstream fetched %d times, want 1", fetches.Load())
+	}
+}
+
+func TestCoalescedWaitKeepsStaleEntry(t *testing.T) {
+	var failing atomic.Bool
+	config := strings.Replace(staleConfig("staleIfError: 1m"), "  keyConfig:", "  coalesceTimeout: 20ms\n  keyConfig:", 1)
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("good"))
+	})
+
+	proxy.get(t, "/x")
+	failing.Store(true)
+	time.Sleep(150 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := proxy.get(t, "/x")
+			if resp.body != "good" || resp.header.Get("X-Hermyx-Cache") != "STALE-IF-ERROR" {
+				t.Errorf("got %d %q %q, want the stale entry", resp.status, resp.body, resp.header.Get("X-Hermyx-Cache"))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"hermyx/pkg/cachemanager"
+	"hermyx/pkg/models"
+	"hermyx/pkg/utils/compress"
+	"slices"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// encodingVariants are the values the encoding key part normalizes
+// Accept-Encoding to.
+var encodingVariants = []string{compress.BROTLI, compress.GZIP, compress.IDENTITY}
+
+// compressibleTypes are the media types worth compressing at the edge, on
+// top of every text/* type and the +json and +xml structured syntaxes.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+}
+
+func keysOnEncoding(cr *compiledRoute) bool {
+	return slices.Contains(cr.Route.Cache.KeyConfig.Type, models.CACHE_KEY_ENCODING)
+}
+
+// normalizeAcceptEncoding rewrites the client's Accept-Encoding to the
+// variant its cache key names, so that what the upstream sends back is
+// exactly the variant stored under that key.
+func normalizeAcceptEncoding(header *fasthttp.RequestHeader) {
+	header.Set(fasthttp.HeaderAcceptEncoding, compress.Negotiate(header.Peek(fasthttp.HeaderAcceptEncoding)))
+}
+
+// compressForClient compresses an uncompressed response on its way to a
+// client that accepts br or gzip. The cache keeps the uncompressed copy.
+// Streamed bodies are passed on as they are.
+func (engine *HermyxEngine) compressForClient(ctx *fasthttp.RequestCtx, cr *compiledRoute) {
+	resp := &ctx.Response
+	if !models.Setting(cr.Route.Cache.EdgeCompression) || resp.IsBodyStream() {
+		return
+	}
+
+	status := resp.StatusCode()
+	if status == fasthttp.StatusNoContent || status == fasthttp.StatusNotModified || status == fasthttp.StatusPartialContent {
+		return
+	}
+	if len(resp.Header.ContentEncoding()) > 0 || !compressible(string(resp.Header.ContentType())) {
+		return
+	}
+	if strings.Contains(strings.ToLower(string(resp.Header.Peek(fasthttp.HeaderCacheControl))), "no-transform") {
+		return
+	}
+
+	// Whether or not this client gets a compressed body, another one
+	// asking for the same URI may.
+	cachemanager.AddVary(&resp.Header, fasthttp.HeaderAcceptEncoding)
+
+	encoding := compress.Negotiate(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding))
+	body := resp.Body()
+	if encoding == compress.IDENTITY || len(body) < compressionMinSize(cr.Route.Cache) {
+		return
+	}
+
+	compressed, err := compress.Encode(encoding, body)
+	if err != nil {
+		engine.logger.Warn(fmt.Sprintf("Unable to compress response for %s with %s: %v", string(ctx.Path()), encoding, err))
+		return
+	}
+	if len(compressed) >= len(body) {
+		return
+	}
+
+	engine.logger.Debug(fmt.Sprintf("Compressed response for %s with %s from %d to %d bytes", string(ctx.Path()), encoding, len(body), len(compressed)))
+	resp.SetBody(compressed)
+	resp.Header.SetContentEncoding(encoding)
+	cachemanager.WeakenETag(&resp.Header)
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// withoutEncodingVary drops Accept-Encoding from a route's Vary list when
+// the cache key already separates the encodings.
+func withoutEncodingVary(cr *compiledRoute, vary []string) []string {
+	if !keysOnEncoding(cr) {
+		return vary
+	}
+	return slices.DeleteFunc(vary, func(name string) bool {
+		return name == "accept-encoding"
+	})
+}
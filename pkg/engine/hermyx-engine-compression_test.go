@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var largeText = strings.Repeat("Hermyx caches responses. ", 400)
+
+func TestStoredCompression(t *testing.T) {
+	var fetches atomic.Int32
+	config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 1m\n  compression: zstd\n", 1)
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(largeText))
+	})
+
+	if resp := proxy.get(t, "/x"); resp.body != largeText {
+		t.Fatalf("miss served %d bytes, want the upstream body", len(resp.body))
+	}
+
+	plain := proxy.get(t, "/x")
+	if plain.body != largeText || plain.header.Get("Content-Encoding") != "" {
+		t.Errorf("hit for a client without zstd: %d bytes with Content-Encoding %q", len(plain.body), plain.header.Get("Content-Encoding"))
+	}
+
+	encoded := proxy.do(t, http.MethodGet, "/x", map[string]string{"Accept-Encoding": "zstd"})
+	if encoded.header.Get("Content-Encoding") != "zstd" {
+		t.Fatalf("hit for a zstd client: Content-Encoding %q", encoded.header.Get("Content-Encoding"))
+	}
+	decoder, err := zstd.NewReader(bytes.NewReader([]byte(encoded.body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	if decoded, err := io.ReadAll(decoder); err != nil || string(decoded) != largeText {
+		t.Errorf("zstd body decoded to %d bytes, %v", len(decoded), err)
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("upstream fetched %d times, want 1", fetches.Load())
+	}
+}
+
+func TestEdgeCompression(t *testing.T) {
+	config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 1m\n  edgeCompression: true\n", 1)
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{name: "gzip client", path: "/text", acceptEncoding: "gzip", encoding: "gzip"},
+		{name: "brotli preferred", path: "/text", acceptEncoding: "gzip, br", encoding: "br"},
+		{name: "identity client", path: "/text"},
+		{name: "image", path: "/image", acceptEncoding: "gzip"},
+		{name: "no-transform", path: "/no-transform", acceptEncoding: "gzip"},
+		{name: "small body", path: "/small", acceptEncoding: "gzip"},
+	}
+
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/no-transform":
+			w.Header().Set("Cache-Control", "no-transform")
+			w.Header().Set("Content-Type", "text/plain")
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		if r.URL.Path == "/small" {
+			w.Write([]byte("tiny"))
+			return
+		}
+		w.Write([]byte(largeText))
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The miss and the hit are compressed alike.
+			for range 2 {
+				resp := proxy.do(t, http.MethodGet, tt.path, map[string]string{"Accept-Encoding": tt.acceptEncoding})
+				if got := resp.header.Get("Content-Encoding"); got != tt.encoding {
+					t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+				}
+				if tt.encoding != "" && len(resp.body) >= len(largeText) {
+					t.Errorf("compressed body is %d bytes", len(resp.body))
+				}
+			}
+		})
+	}
+}
+
+func TestEncodingKeyedVariants(t *testing.T) {
+	var fetches atomic.Int32
+	config := strings.Replace(testConfig, "type: [method, path, query]", "type: [method, path, encoding]", 1)
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+
+	tests := []struct {
+		acceptEncoding string
+		variant        string
+	}{
+		{acceptEncoding: "gzip, deflate", variant: "gzip"},
+		{acceptEncoding: "br;q=1, gzip;q=0.5", variant: "br"},
+		{acceptEncoding: "", variant: "identity"},
+		{acceptEncoding: "gzip", variant: "gzip"},
+		{acceptEncoding: "deflate", variant: "identity"},
+	}
+
+	for _, tt := range tests {
+		resp := proxy.do(t, http.MethodGet, "/x", map[string]string{"Accept-Encoding": tt.acceptEncoding})
+		if resp.body != tt.variant {
+			t.Errorf("Accept-Encoding %q served the %q variant, want %q", tt.acceptEncoding, resp.body, tt.variant)
+		}
+	}
+	if fetches.Load() != 3 {
+		t.Errorf("upstream fetched %d times, want one per variant", fetches.Load())
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	tests := map[string]bool{
+		"text/html":                       true,
+		"text/plain; charset=utf-8":       true,
+		"application/json":                true,
+		"application/problem+json":        true,
+		"application/atom+xml":            true,
+		"IMAGE/SVG+XML":                   true,
+		"image/png":                       false,
+		"application/octet-stream":        false,
+		"":                                false,
+		"application/zip; name=text/html": false,
+	}
+	for contentType, want := range tests {
+		if got := compressible(contentType); got != want {
+			t.Errorf("compressible(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"hermyx/pkg/models"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// unsafeMethods may change the state of the resource they target, so a
+// successful one invalidates what is cached for it (RFC 9111 §4.4).
+var unsafeMethods = map[string]bool{
+	"post":   true,
+	"put":    true,
+	"patch":  true,
+	"delete": true,
+}
+
+// pathTagPrefix marks the internal tag that routes with invalidateOnUnsafe
+// attach to every entry, so that all variants of a path can be found again.
+const pathTagPrefix = "hermyx-path:"
+
+func pathTag(path string) string {
+	return pathTagPrefix + path
+}
+
+func withPathTag(tags []string, reqHeader *fasthttp.RequestHeader) []string {
+	var uri fasthttp.URI
+	if err := uri.Parse(nil, reqHeader.RequestURI()); err != nil {
+		return tags
+	}
+
+	tag := pathTag(string(uri.Path()))
+	if slices.Contains(tags, tag) {
+		return tags
+	}
+	return append(tags, tag)
+}
+
+// invalidateAfterUnsafe purges the entries cached for the target of a
+// successful unsafe request, and for the same-origin URIs the upstream
+// names in Location and Content-Location.
+func (engine *HermyxEngine) invalidateAfterUnsafe(ctx *fasthttp.RequestCtx) {
+	status := ctx.Response.StatusCode()
+	if status < 200 || status >= 400 {
+		return
+	}
+
+	path := string(ctx.Path())
+	cr := engine.matchPath(path)
+	if cr == nil || cr.Route.Cache == nil || !models.Setting(cr.Route.Cache.InvalidateOnUnsafe) {
+		return
+	}
+
+	paths := []string{path}
+	for _, header := range []string{fasthttp.HeaderLocation, fasthttp.HeaderContentLocation} {
+		if target, ok := sameOriginPath(ctx, string(ctx.Response.Header.Peek(header))); ok && !slices.Contains(paths, target) {
+			paths = append(paths, target)
+		}
+	}
+
+	for _, target := range paths {
+		purged, err := engine.cacheManager.PurgeTag(pathTag(target))
+		if err != nil {
+			engine.logger.Error(fmt.Sprintf("Unable to invalidate cached entries for %s: %v", target, err))
+			continue
+		}
+		engine.logger.Info(fmt.Sprintf("%s %s invalidated %d cached entries for %s", string(ctx.Method()), path, purged, target))
+	}
+}
+
+// sameOriginPath resolves a Location-style header value against the request
+// and returns its path, unless it points at another host.
+func sameOriginPath(ctx *fasthttp.RequestCtx, value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+
+	ref, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	if ref.Host != "" && !strings.EqualFold(ref.Host, string(ctx.Host())) {
+		return "", false
+	}
+
+	base := &url.URL{Path: string(ctx.Path())}
+	return base.ResolveReference(ref).Path, true
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUnsafeRequestsInvalidate(t *testing.T) {
+	config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 1m\n  invalidateOnUnsafe: true\n", 1)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		status   int
+		location string
+		dropped  []string
+	}{
+		{name: "post to the path", method: http.MethodPost, path: "/items", status: http.StatusCreated, dropped: []string{"/items"}},
+		{name: "delete", method: http.MethodDelete, path: "/items/1", status: http.StatusNoContent, dropped: []string{"/items/1"}},
+		{name: "location", method: http.MethodPost, path: "/items", status: http.StatusCreated, location: "/items/1", dropped: []string{"/items", "/items/1"}},
+		{name: "relative location", method: http.MethodPut, path: "/items/2", status: http.StatusOK, location: "1", dropped: []string{"/items/2", "/items/1"}},
+		{name: "other host", method: http.MethodPost, path: "/other", status: http.StatusCreated, location: "http://elsewhere.example/items/1"},
+		{name: "failed request", method: http.MethodPost, path: "/items", status: http.StatusInternalServerError},
+		{name: "safe method", method: http.MethodGet, path: "/other", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					if tt.location != "" {
+						w.Header().Set("Location", tt.location)
+					}
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte("body"))
+			})
+
+			cached := []string{"/items", "/items/1", "/items/2"}
+			for _, path := range cached {
+				proxy.get(t, path)
+			}
+
+			if resp := proxy.do(t, tt.method, tt.path, nil); resp.status != tt.status {
+				t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, resp.status, tt.status)
+			}
+
+			for _, path := range cached {
+				want := "HIT"
+				if slices.Contains(tt.dropped, path) {
+					want = ""
+				}
+				if got := proxy.get(t, path).header.Get("X-Hermyx-Cache"); got != want {
+					t.Errorf("GET %s: X-Hermyx-Cache %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
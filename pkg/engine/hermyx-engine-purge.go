@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// banHeader carries the regular expression of a BAN request. It is matched
+// against the request URI (path and query) of later cache lookups.
+const banHeader = "X-Hermyx-Ban"
+
+type banRule struct {
+	pattern   *regexp.Regexp
+	createdAt time.Time
+}
+
+type banResult struct {
+	Pattern string `json:"pattern"`
+	Active  int    `json:"active"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (engine *HermyxEngine) compilePurgeConfig() {
+	if engine.config.Purge == nil {
+		return
+	}
+
+	for _, cidr := range engine.config.Purge.AllowedCidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("Invalid purge.allowedCidrs entry %q: %v", cidr, err)
+		}
+		engine.purgeNets = append(engine.purgeNets, network)
+	}
+
+	for _, cr := range engine.compiledRoutes {
+		if cr.Route.Cache != nil && cr.Route.Cache.Enabled {
+			engine.noteLifetime(cr.Route.Cache.Ttl + staleRetention(cr.Route.Cache))
+		}
+	}
+}
+
+// handlePurgeMethod answers PURGE and BAN requests when the purge block is
+// configured. It reports whether the request was handled.
+func (engine *HermyxEngine) handlePurgeMethod(ctx *fasthttp.RequestCtx, method string) bool {
+	if engine.config.Purge == nil || (method != "purge" && method != "ban") {
+		return false
+	}
+
+	if !engine.purgeAllowed(ctx) {
+		engine.logger.Warn(fmt.Sprintf("Rejected %s %s from %s", strings.ToUpper(method), string(ctx.Path()), ctx.RemoteIP()))
+		writeAdminJSON(ctx, fasthttp.StatusForbidden, purgeResult{Error: "forbidden"})
+		return true
+	}
+
+	if method == "ban" {
+		engine.handleBan(ctx)
+	} else {
+		engine.handlePurge(ctx)
+	}
+	return true
+}
+
+// purgeAllowed accepts clients from an allowed network and clients that
+// present the admin token.
+func (engine *HermyxEngine) purgeAllowed(ctx *fasthttp.RequestCtx) bool {
+	ip := ctx.RemoteIP()
+	for _, network := range engine.purgeNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return engine.config.Admin != nil && engine.adminAuthorized(ctx)
+}
+
+// handlePurge drops the entry a GET or HEAD for the same URI would be
+// served from, together with its Vary variants.
+func (engine *HermyxEngine) handlePurge(ctx *fasthttp.RequestCtx) {
+	path := string(ctx.Path())
+	cr := engine.matchPath(path)
+	if cr == nil || cr.Route.Cache == nil || cr.Route.Cache.KeyConfig == nil {
+		writeAdminJSON(ctx, fasthttp.StatusNotFound, purgeResult{Error: "no cached route matches " + path})
+		return
+	}
+
+	method := append([]byte(nil), ctx.Method()...)
+	defer ctx.Request.Header.SetMethodBytes(method)
+
+	// Every encoding variant of the URI goes, not only the one the PURGE
+	// request itself would be keyed on.
+	encodings := []string{""}
+	if keysOnEncoding(cr) {
+		encodings = encodingVariants
+		acceptEncoding := append([]byte(nil), ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)...)
+		defer restoreRequestHeader(&ctx.Request.Header, fasthttp.HeaderAcceptEncoding, acceptEncoding)
+	}
+
+	purged := 0
+	for _, lookupMethod := range []string{fasthttp.MethodGet, fasthttp.MethodHead} {
+		ctx.Request.Header.SetMethod(lookupMethod)
+		for _, encoding := range encodings {
+			if encoding != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAcceptEncoding, encoding)
+			}
+			key, _ := cr.Keys.Build(ctx)
+
+			count, err := engine.cacheManager.PurgeKey(key)
+			purged += count
+			if err != nil {
+				engine.logger.Error(fmt.Sprintf("PURGE %s failed for key %s: %v", path, key, err))
+				writeAdminJSON(ctx, fasthttp.StatusInternalServerError, purgeResult{Purged: purged, Error: err.Error()})
+				return
+			}
+		}
+	}
+
+	engine.logger.Info(fmt.Sprintf("PURGE %s removed %d entries", string(ctx.RequestURI()), purged))
+	writeAdminJSON(ctx, fasthttp.StatusOK, purgeResult{Purged: purged})
+}
+
+// handleBan records a ban rule. Nothing is scanned: entries stored before
+// the ban are dropped when a lookup whose URI matches it finds them.
+func (engine *HermyxEngine) handleBan(ctx *fasthttp.RequestCtx) {
+	expr := string(ctx.Request.Header.Peek(banHeader))
+	if expr == "" {
+		writeAdminJSON(ctx, fasthttp.StatusBadRequest, banResult{Error: "missing " + banHeader + " header"})
+		return
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		writeAdminJSON(ctx, fasthttp.StatusBadRequest, banResult{Pattern: expr, Error: err.Error()})
+		return
+	}
+
+	engine.bansMu.Lock()
+	engine.bans = append(engine.bans, banRule{pattern: pattern, createdAt: time.Now()})
+	active := len(engine.bans)
+	engine.bansMu.Unlock()
+
+	engine.logger.Info(fmt.Sprintf("BAN %q added; %d ban rules active", expr, active))
+	writeAdminJSON(ctx, fasthttp.StatusOK, banResult{Pattern: expr, Active: active})
+}
+
+// banned reports whether an entry stored at storedAt for the request URI is
+// covered by a ban issued after it was stored.
+func (engine *HermyxEngine) banned(requestURI string, storedAt time.Time) bool {
+	engine.pruneBans()
+
+	engine.bansMu.RLock()
+	defer engine.bansMu.RUnlock()
+
+	for _, ban := range engine.bans {
+		if storedAt.Before(ban.createdAt) && ban.pattern.MatchString(requestURI) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneBans drops ban rules older than the longest lifetime any entry has
+// been stored with, since no entry they could match is left.
+func (engine *HermyxEngine) pruneBans() {
+	cutoff := time.Now().Add(-time.Duration(engine.maxLifetime.Load()))
+
+	engine.bansMu.RLock()
+	expired := len(engine.bans) > 0 && engine.bans[0].createdAt.Before(cutoff)
+	engine.bansMu.RUnlock()
+	if !expired {
+		return
+	}
+
+	engine.bansMu.Lock()
+	defer engine.bansMu.Unlock()
+
+	pruned := 0
+	for pruned < len(engine.bans) && engine.bans[pruned].createdAt.Before(cutoff) {
+		pruned++
+	}
+	engine.bans = engine.bans[pruned:]
+	engine.logger.Debug(fmt.Sprintf("Pruned %d expired ban rules", pruned))
+}
+
+// noteLifetime records how long the backend keeps an entry, so that ban
+// rules are kept for at least as long.
+func (engine *HermyxEngine) noteLifetime(lifetime time.Duration) {
+	for {
+		current := engine.maxLifetime.Load()
+		if int64(lifetime) <= current || engine.maxLifetime.CompareAndSwap(current, int64(lifetime)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func purgeConfig(cidr string) string {
+	return testConfig + "admin: {port: 2, token: secret}\npurge: {allowedCidrs: [" + cidr + "]}\n"
+}
+
+func TestPurgeMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "allowed network", cidr: "127.0.0.0/8", status: http.StatusOK},
+		{name: "allowed address", cidr: "127.0.0.1", status: http.StatusOK},
+		{name: "admin token", cidr: "10.0.0.0/8", headers: map[string]string{"Authorization": "Bearer secret"}, status: http.StatusOK},
+		{name: "other network", cidr: "10.0.0.0/8", status: http.StatusForbidden},
+		{name: "wrong token", cidr: "10.0.0.0/8", headers: map[string]string{"Authorization": "Bearer nope"}, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t, purgeConfig(tt.cidr), func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("body"))
+			})
+			proxy.get(t, "/x?a=1")
+			proxy.get(t, "/y")
+
+			if resp := proxy.do(t, "PURGE", "/x?a=1", tt.headers); resp.status != tt.status {
+				t.Fatalf("PURGE = %d %s, want %d", resp.status, resp.body, tt.status)
+			}
+
+			want := ""
+			if tt.status != http.StatusOK {
+				want = "HIT"
+			}
+			if got := proxy.get(t, "/x?a=1").header.Get("X-Hermyx-Cache"); got != want {
+				t.Errorf("purged entry: X-Hermyx-Cache %q, want %q", got, want)
+			}
+			if got := proxy.get(t, "/y").header.Get("X-Hermyx-Cache"); got != "HIT" {
+				t.Errorf("other entry: X-Hermyx-Cache %q, want HIT", got)
+			}
+		})
+	}
+}
+
+func TestPurgeMethodIsProxiedWithoutPurgeConfig(t *testing.T) {
+	var method string
+	proxy := newTestProxy(t, testConfig, func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+	})
+	proxy.do(t, "PURGE", "/x", nil)
+	if method != "PURGE" {
+		t.Errorf("upstream saw %q, want the PURGE request", method)
+	}
+}
+
+func TestBanMethod(t *testing.T) {
+	proxy := newTestProxy(t, purgeConfig("127.0.0.1"), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	})
+	for _, path := range []string{"/products/1", "/products/2?x=1", "/users/1"} {
+		proxy.get(t, path)
+	}
+
+	if resp := proxy.do(t, "BAN", "/", map[string]string{"X-Hermyx-Ban": "^/products/"}); resp.status != http.StatusOK || !strings.Contains(resp.body, `"active":1`) {
+		t.Fatalf("BAN = %d %s", resp.status, resp.body)
+	}
+
+	for path, want := range map[string]string{"/products/1": "", "/products/2?x=1": "", "/users/1": "HIT"} {
+		if got := proxy.get(t, path).header.Get("X-Hermyx-Cache"); got != want {
+			t.Errorf("GET %s after the ban: X-Hermyx-Cache %q, want %q", path, got, want)
+		}
+	}
+	if got := proxy.get(t, "/products/1").header.Get("X-Hermyx-Cache"); got != "HIT" {
+		t.Errorf("entry stored after the ban: X-Hermyx-Cache %q, want HIT", got)
+	}
+
+	for name, headers := range map[string]map[string]string{"missing header": nil, "invalid expression": {"X-Hermyx-Ban": "("}} {
+		if resp := proxy.do(t, "BAN", "/", headers); resp.status != http.StatusBadRequest {
+			t.Errorf("%s: BAN = %d, want 400", name, resp.status)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxRanges bounds the ranges served from one request. A request asking for
+// more is answered with the full body, which RFC 9110 §14.2 allows.
+const maxRanges = 16
+
+// rangeRequest is the Range and If-Range of a client request. Both are taken
+// off the request so that the upstream sends the full object, which can then
+// be cached and sliced for this and later requests. When the object is too
+// large to cache, they are put back and the range is fetched instead.
+//
+// Accept-Encoding is taken as well: ranges are cut from the identity body,
+// never from one compressed at rest or on its way to the client, whose
+// bytes and weakened ETag would not match what the client asked for.
+type rangeRequest struct {
+	spec           string
+	ifRange        string
+	acceptEncoding string
+}
+
+type byteRange struct {
+	start, end int
+}
+
+func takeRange(header *fasthttp.RequestHeader) *rangeRequest {
+	spec := string(header.Peek(fasthttp.HeaderRange))
+	if spec == "" {
+		return nil
+	}
+
+	request := &rangeRequest{
+		spec:           spec,
+		ifRange:        string(header.Peek(fasthttp.HeaderIfRange)),
+		acceptEncoding: string(header.Peek(fasthttp.HeaderAcceptEncoding)),
+	}
+	header.Del(fasthttp.HeaderRange)
+	header.Del(fasthttp.HeaderIfRange)
+	header.Del(fasthttp.HeaderAcceptEncoding)
+	return request
+}
+
+// restore puts the headers taken by takeRange back on the request.
+func (request *rangeRequest) restore(header *fasthttp.RequestHeader) {
+	header.Set(fasthttp.HeaderRange, request.spec)
+	if request.ifRange != "" {
+		header.Set(fasthttp.HeaderIfRange, request.ifRange)
+	}
+	if request.acceptEncoding != "" {
+		header.Set(fasthttp.HeaderAcceptEncoding, request.acceptEncoding)
+	}
+}
+
+// tooLargeToCache reports whether a full response passed the route's
+// maxContentSize, so that the cache never gets an object to slice.
+func tooLargeToCache(cr *compiledRoute, resp *fasthttp.Response) bool {
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return false
+	}
+	return resp.IsBodyStream() || uint64(len(resp.Body())) > cr.Route.Cache.MaxContentSize
+}
+
+// proxyRange drops a full response too large to cache and forwards the
+// client's range request instead. The upstream's answer, usually a 206, is
+// passed through and never cached.
+func (engine *HermyxEngine) proxyRange(ctx *fasthttp.RequestCtx, cr *compiledRoute, request *rangeRequest) {
+	engine.logger.Info(fmt.Sprintf("%s is too large to cache; forwarding Range %q upstream", string(ctx.Path()), request.spec))
+
+	ctx.Response.ResetBody()
+	request.restore(&ctx.Request.Header)
+	if err := engine.fetchUpstream(ctx, cr.Route.Target, 0); err != nil {
+		engine.logger.Error(fmt.Sprintf("Proxy error for ranged %s: %v", string(ctx.Path()), err))
+		ctx.Error("Proxy error: "+err.Error(), fasthttp.StatusBadGateway)
+	}
+}
+
+// applyRange advertises range support on a full 200 response and, when the
+// client asked for ranges, answers them from the full body.
+func (engine *HermyxEngine) applyRange(ctx *fasthttp.RequestCtx, request *rangeRequest) {
+	resp := &ctx.Response
+	if resp.StatusCode() != fasthttp.StatusOK || resp.IsBodyStream() {
+		return
+	}
+	if len(resp.Header.Peek(fasthttp.HeaderAcceptRanges)) == 0 {
+		resp.Header.Set(fasthttp.HeaderAcceptRanges, "bytes")
+	}
+
+	if request == nil || !ctx.IsGet() || !request.current(&resp.Header) {
+		return
+	}
+
+	body := resp.Body()
+	ranges, ok := parseRanges(request.spec, len(body))
+	if !ok {
+		return
+	}
+
+	if len(ranges) == 0 {
+		engine.logger.Debug(fmt.Sprintf("Range %q of %s is not satisfiable", request.spec, string(ctx.Path())))
+		resp.SetStatusCode(fasthttp.StatusRequestedRangeNotSatisfiable)
+		resp.Header.Set(fasthttp.HeaderContentRange, fmt.Sprintf("bytes */%d", len(body)))
+		resp.Header.Del(fasthttp.HeaderContentEncoding)
+		resp.ResetBody()
+		return
+	}
+
+	engine.logger.Debug(fmt.Sprintf("Serving %d ranges of %s from the full body", len(ranges), string(ctx.Path())))
+	if len(ranges) == 1 {
+		part := append([]byte(nil), body[ranges[0].start:ranges[0].end+1]...)
+		resp.SetStatusCode(fasthttp.StatusPartialContent)
+		resp.Header.Set(fasthttp.HeaderContentRange, ranges[0].contentRange(len(body)))
+		resp.SetBody(part)
+		return
+	}
+
+	boundary := multipartBoundary()
+	contentType := string(resp.Header.ContentType())
+
+	var multipart bytes.Buffer
+	for _, r := range ranges {
+		fmt.Fprintf(&multipart, "--%s\r\n", boundary)
+		if contentType != "" {
+			fmt.Fprintf(&multipart, "%s: %s\r\n", fasthttp.HeaderContentType, contentType)
+		}
+		fmt.Fprintf(&multipart, "%s: %s\r\n\r\n", fasthttp.HeaderContentRange, r.contentRange(len(body)))
+		multipart.Write(body[r.start : r.end+1])
+		multipart.WriteString("\r\n")
+	}
+	fmt.Fprintf(&multipart, "--%s--\r\n", boundary)
+
+	resp.SetStatusCode(fasthttp.StatusPartialContent)
+	resp.Header.SetContentType("multipart/byteranges; boundary=" + boundary)
+	resp.SetBody(multipart.Bytes())
+}
+
+// current evaluates If-Range (RFC 9110 §13.1.5): ranges are only served when
+// the client's copy is the one in the response, compared strongly.
+func (request *rangeRequest) current(header *fasthttp.ResponseHeader) bool {
+	if request.ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(request.ifRange, `"`) || strings.HasPrefix(request.ifRange, "W/") {
+		etag := string(header.Peek(fasthttp.HeaderETag))
+		return !strings.HasPrefix(request.ifRange, "W/") && !strings.HasPrefix(etag, "W/") && etag == request.ifRange
+	}
+
+	since, err := fasthttp.ParseHTTPDate([]byte(request.ifRange))
+	if err != nil {
+		return false
+	}
+	lastModified, err := fasthttp.ParseHTTPDate(header.Peek(fasthttp.HeaderLastModified))
+	return err == nil && lastModified.Equal(since)
+}
+
+// parseRanges reads a bytes Range header against a body of the given size.
+// It reports false when the header is to be ignored, and no ranges when none
+// of them is satisfiable.
+func parseRanges(spec string, size int) ([]byteRange, bool) {
+	unit, set, ok := strings.Cut(spec, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, false
+	}
+
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, false
+	}
+
+	var ranges []byteRange
+	for _, spec := range specs {
+		first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return nil, false
+		}
+
+		var r byteRange
+		switch {
+		case first == "":
+			suffix, err := strconv.Atoi(last)
+			if err != nil || suffix < 0 {
+				return nil, false
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			r = byteRange{start: max(size-suffix, 0), end: size - 1}
+		default:
+			start, err := strconv.Atoi(first)
+			if err != nil || start < 0 {
+				return nil, false
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.Atoi(last); err != nil || end < start {
+					return nil, false
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: min(end, size-1)}
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, true
+}
+
+func (r byteRange) contentRange(size int) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+func multipartBoundary() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		spec string
+		size int
+		want []byteRange
+		ok   bool
+	}{
+		{spec: "bytes=0-4", size: 10, want: []byteRange{{0, 4}}, ok: true},
+		{spec: "bytes=5-", size: 10, want: []byteRange{{5, 9}}, ok: true},
+		{spec: "bytes=-3", size: 10, want: []byteRange{{7, 9}}, ok: true},
+		{spec: "bytes=-30", size: 10, want: []byteRange{{0, 9}}, ok: true},
+		{spec: "bytes=8-20", size: 10, want: []byteRange{{8, 9}}, ok: true},
+		{spec: "BYTES = 0-0, 2-3", size: 10, want: []byteRange{{0, 0}, {2, 3}}, ok: true},
+		{spec: "bytes=10-", size: 10, want: nil, ok: true},
+		{spec: "bytes=-0", size: 10, want: nil, ok: true},
+		{spec: "bytes=0-1", size: 0, want: nil, ok: true},
+		{spec: "bytes=20-30, 0-1", size: 10, want: []byteRange{{0, 1}}, ok: true},
+		{spec: "items=0-1", size: 10},
+		{spec: "bytes", size: 10},
+		{spec: "bytes=4-2", size: 10},
+		{spec: "bytes=a-2", size: 10},
+		{spec: "bytes=0-b", size: 10},
+		{spec: "bytes=-x", size: 10},
+		{spec: "bytes=5", size: 10},
+		{spec: "bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0", size: 10},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRanges(tt.spec, tt.size)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRanges(%q, %d) = %v, %v, want %v, %v", tt.spec, tt.size, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRangeRequestCurrent(t *testing.T) {
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var header fasthttp.ResponseHeader
+	header.Set(fasthttp.HeaderETag, `"v1"`)
+	header.Set(fasthttp.HeaderLastModified, modified.Format(http.TimeFormat))
+
+	var weak fasthttp.ResponseHeader
+	weak.Set(fasthttp.HeaderETag, `W/"v1"`)
+
+	tests := []struct {
+		name    string
+		ifRange string
+		header  *fasthttp.ResponseHeader
+		want    bool
+	}{
+		{name: "no If-Range", header: &header, want: true},
+		{name: "matching etag", ifRange: `"v1"`, header: &header, want: true},
+		{name: "other etag", ifRange: `"v2"`, header: &header, want: false},
+		{name: "weak If-Range", ifRange: `W/"v1"`, header: &header, want: false},
+		{name: "weak response etag", ifRange: `"v1"`, header: &weak, want: false},
+		{name: "matching date", ifRange: modified.Format(http.TimeFormat), header: &header, want: true},
+		{name: "other date", ifRange: modified.Add(time.Second).Format(http.TimeFormat), header: &header, want: false},
+		{name: "no Last-Modified", ifRange: modified.Format(http.TimeFormat), header: &weak, want: false},
+		{name: "garbage", ifRange: "yesterday", header: &header, want: false},
+	}
+
+	for _, tt := range tests {
+		request := &rangeRequest{spec: "bytes=0-1", ifRange: tt.ifRange}
+		if got := request.current(tt.header); got != tt.want {
+			t.Errorf("%s: current = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRangesAreServedFromTheCache(t *testing.T) {
+	var fetches atomic.Int32
+	var forwarded atomic.Value
+	proxy := newTestProxy(t, testConfig, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		forwarded.Store(r.Header.Get("Range") + r.Header.Get("If-Range"))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("0123456789"))
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+		cache   string
+	}{
+		{name: "ranged miss", headers: map[string]string{"Range": "bytes=0-3"}, status: http.StatusPartialContent, body: "0123"},
+		{name: "ranged hit", headers: map[string]string{"Range": "bytes=-2"}, status: http.StatusPartialContent, body: "89", cache: "HIT"},
+		{name: "matching If-Range", headers: map[string]string{"Range": "bytes=2-4", "If-Range": `"v1"`}, status: http.StatusPartialContent, body: "234", cache: "HIT"},
+		{name: "stale If-Range", headers: map[string]string{"Range": "bytes=2-4", "If-Range": `"v0"`}, status: http.StatusOK, body: "0123456789", cache: "HIT"},
+		{name: "unsatisfiable", headers: map[string]string{"Range": "bytes=20-"}, status: http.StatusRequestedRangeNotSatisfiable, cache: "HIT"},
+		{name: "full", status: http.StatusOK, body: "0123456789", cache: "HIT"},
+	}
+
+	for _, tt := range tests {
+		resp := proxy.do(t, http.MethodGet, "/file", tt.headers)
+		if resp.status != tt.status || resp.body != tt.body {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.status, resp.body, tt.status, tt.body)
+		}
+		if got := resp.header.Get("X-Hermyx-Cache"); got != tt.cache {
+			t.Errorf("%s: X-Hermyx-Cache = %q, want %q", tt.name, got, tt.cache)
+		}
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("upstream fetched %d times, want 1", fetches.Load())
+	}
+	if got := forwarded.Load(); got != "" {
+		t.Errorf("upstream got Range and If-Range %q, want neither", got)
+	}
+}
+
+func TestMultipleRanges(t *testing.T) {
+	proxy := newTestProxy(t, testConfig, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("0123456789"))
+	})
+
+	resp := proxy.do(t, http.MethodGet, "/file", map[string]string{"Range": "bytes=0-1,8-"})
+	if resp.status != http.StatusPartialContent || !strings.HasPrefix(resp.header.Get("Content-Type"), "multipart/byteranges; boundary=") {
+		t.Fatalf("got %d %q, want a multipart 206", resp.status, resp.header.Get("Content-Type"))
+	}
+	for _, part := range []string{"Content-Range: bytes 0-1/10\r\n\r\n01\r\n", "Content-Range: bytes 8-9/10\r\n\r\n89\r\n"} {
+		if !strings.Contains(resp.body, part) {
+			t.Errorf("multipart body %q lacks %q", resp.body, part)
+		}
+	}
+}
+
+// rangeUpstream answers ranges itself, with a 206 and a body naming them.
+func rangeUpstream(fetches *atomic.Int32, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if spec := r.Header.Get("Range"); spec != "" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("upstream " + spec))
+			return
+		}
+		w.Write([]byte(body))
+	}
+}
+
+func TestRangesPassThroughWhenNotCacheable(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		body    string
+		fetches int32
+	}{
+		{
+			name:    "cache disabled",
+			config:  strings.Replace(testConfig, "cache: {enabled: true}", "cache: {enabled: false}", 1),
+			body:    "small",
+			fetches: 1,
+		},
+		{
+			name:    "larger than maxContentSize",
+			config:  strings.Replace(testConfig, "ttl: 1m", "ttl: 1m\n  maxContentSize: 16", 1),
+			body:    strings.Repeat("x", 1024),
+			fetches: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			proxy := newTestProxy(t, tt.config, rangeUpstream(&fetches, tt.body))
+
+			for i := 0; i < 2; i++ {
+				resp := proxy.do(t, http.MethodGet, "/video", map[string]string{"Range": "bytes=0-1"})
+				if resp.status != http.StatusPartialContent || resp.body != "upstream bytes=0-1" {
+					t.Fatalf("got %d %q, want the upstream's 206", resp.status, resp.body)
+				}
+				if got := resp.header.Get("X-Hermyx-Cache"); got != "" {
+					t.Errorf("X-Hermyx-Cache = %q on a passed-through range", got)
+				}
+			}
+			if fetches.Load() != 2*tt.fetches {
+				t.Errorf("upstream fetched %d times, want %d", fetches.Load(), 2*tt.fetches)
+			}
+		})
+	}
+}
+
+func TestRangesOfStreamedObjectsAreForwarded(t *testing.T) {
+	config := strings.Replace(testConfig, "ttl: 1m", "ttl: 1m\n  maxContentSize: 16", 1)
+
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if spec := r.Header.Get("Range"); spec != "" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("upstream " + spec))
+			return
+		}
+		// Without a Content-Length the body is only found too large once
+		// it has been read past the limit.
+		for i := 0; i < 64; i++ {
+			w.Write([]byte("chunk of a large body "))
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	resp := proxy.do(t, http.MethodGet, "/live", map[string]string{"Range": "bytes=5-9"})
+	if resp.status != http.StatusPartialContent || resp.body != "upstream bytes=5-9" {
+		t.Errorf("got %d %q, want the upstream's 206", resp.status, resp.body)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want the full fetch and the ranged one", fetches.Load())
+	}
+}
+
+func TestRangesAreCutFromTheIdentityBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		accept  string
+	}{
+		{name: "edge compression", setting: "edgeCompression: true", accept: "gzip, br"},
+		{name: "stored compression", setting: "compression: zstd", accept: "zstd"},
+		{name: "stored gzip", setting: "compression: gzip", accept: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 1m\n  "+tt.setting+"\n", 1)
+			proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(largeText))
+			})
+
+			for _, cache := range []string{"", "HIT"} {
+				resp := proxy.do(t, http.MethodGet, "/file", map[string]string{
+					"Accept-Encoding": tt.accept,
+					"Range":           "bytes=7-12",
+					"If-Range":        `"v1"`,
+				})
+				if resp.status != http.StatusPartialContent || resp.body != largeText[7:13] {
+					t.Errorf("got %d %q, want a 206 with %q", resp.status, resp.body, largeText[7:13])
+				}
+				if got := resp.header.Get("Content-Encoding"); got != "" {
+					t.Errorf("ranged response has Content-Encoding %q", got)
+				}
+				if got := resp.header.Get("Content-Range"); got != fmt.Sprintf("bytes 7-12/%d", len(largeText)) {
+					t.Errorf("Content-Range = %q, want it to count the identity body", got)
+				}
+				if got := resp.header.Get("X-Hermyx-Cache"); got != cache {
+					t.Errorf("X-Hermyx-Cache = %q, want %q", got, cache)
+				}
+			}
+
+			full := proxy.do(t, http.MethodGet, "/file", map[string]string{"Accept-Encoding": tt.accept})
+			if full.header.Get("Content-Encoding") == "" {
+				t.Error("the full response to the same client is no longer compressed")
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"fmt"
+	"hermyx/pkg/cachemanager"
+	"hermyx/pkg/models"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// staleRetention is how long the backend keeps an entry after it expires,
+// so it remains available to every feature that works with stale entries.
+func staleRetention(config *models.CacheConfig) time.Duration {
+	return max(models.Setting(config.GracePeriod), models.Setting(config.StaleWhileRevalidate), models.Setting(config.StaleIfError))
+}
+
+func withinStaleWindow(res *cachemanager.CachedResponse, window time.Duration) bool {
+	return window > 0 && !res.ExpiresAt.IsZero() && time.Since(res.ExpiresAt) <= window
+}
+
+// mayServeStale reports whether a stale entry may be served without being
+// revalidated first. On routes that respect origin headers, an origin that
+// forbids it with must-revalidate and its kin is obeyed.
+func mayServeStale(cr *compiledRoute, res *cachemanager.CachedResponse, window time.Duration) bool {
+	if models.Setting(cr.Route.Cache.RespectOriginHeaders) && res.ForbidsStale() {
+		return false
+	}
+	return withinStaleWindow(res, window)
+}
+
+// proxyRevalidation proxies the request with the stale entry's validators
+// in place of the client's preconditions, so that the upstream can answer
+// 304 instead of resending the body.
+func (engine *HermyxEngine) proxyRevalidation(ctx *fasthttp.RequestCtx, cr *compiledRoute, key string, stale *cachemanager.CachedResponse) error {
+	header := &ctx.Request.Header
+
+	// The client's own preconditions are put back once the upstream has
+	// answered, so that they can still be evaluated against the entry.
+	ifNoneMatch := append([]byte(nil), header.Peek(fasthttp.HeaderIfNoneMatch)...)
+	ifModifiedSince := append([]byte(nil), header.Peek(fasthttp.HeaderIfModifiedSince)...)
+
+	setConditionalHeaders(header, stale)
+
+	engine.logger.Info(fmt.Sprintf("Revalidating stale entry for key %s with the upstream", key))
+	err := engine.proxyRequest(ctx, cr)
+
+	restoreRequestHeader(header, fasthttp.HeaderIfNoneMatch, ifNoneMatch)
+	restoreRequestHeader(header, fasthttp.HeaderIfModifiedSince, ifModifiedSince)
+
+	return err
+}
+
+// confirmStale handles a 304 answer to a revalidation: the stored entry is
+// refreshed and served in place of the empty upstream response.
+func (engine *HermyxEngine) confirmStale(ctx *fasthttp.RequestCtx, cr *compiledRoute, key string, stale *cachemanager.CachedResponse) {
+	engine.logger.Info(fmt.Sprintf("Upstream confirmed stale entry for key %s; refreshing it", key))
+	refreshTags(cr, &ctx.Response.Header, stale)
+	stale.Refresh(&ctx.Response.Header)
+	ctx.Response.Reset()
+
+	engine.refreshEntry(cr, key, &ctx.Request.Header, stale)
+	engine.serveCached(ctx, cr, stale, "REVALIDATED")
+}
+
+// refreshEntry stores an entry the upstream confirmed with a 304 under a
+// new freshness lifetime, derived from its merged headers.
+func (engine *HermyxEngine) refreshEntry(cr *compiledRoute, key string, reqHeader *fasthttp.RequestHeader, res *cachemanager.CachedResponse) {
+	// Only the headers matter here, so a compressed body is left as it is.
+	var refreshed fasthttp.Response
+	if err := res.WriteTo(&refreshed, []byte(res.BodyEncoding)); err != nil {
+		engine.logger.Error(fmt.Sprintf("Unable to refresh entry for key %s: %v", key, err))
+		return
+	}
+
+	statusTtl, ok := routeStatusTtl(cr.Route.Cache, res.StatusCode)
+	if !ok {
+		return
+	}
+	cacheTtl, vary, ok := engine.cachePolicy(cr, key, statusTtl, reqHeader, &refreshed.Header)
+	if !ok {
+		return
+	}
+	engine.storeResponse(cr, key, reqHeader, res, cacheTtl, vary)
+}
+
+// refreshTags replaces the entry's tags when the 304 carries its own
+// surrogate keys; otherwise the stored ones still apply.
+func refreshTags(cr *compiledRoute, header *fasthttp.ResponseHeader, res *cachemanager.CachedResponse) {
+	if tags := takeSurrogateKeys(cr, header); len(tags) > 0 {
+		res.Tags = tags
+	}
+}
+
+func setConditionalHeaders(header *fasthttp.RequestHeader, res *cachemanager.CachedResponse) {
+	header.Del(fasthttp.HeaderIfNoneMatch)
+	header.Del(fasthttp.HeaderIfModifiedSince)
+
+	if etag := res.Header(fasthttp.HeaderETag); etag != "" {
+		header.Set(fasthttp.HeaderIfNoneMatch, etag)
+	}
+	if lastModified := res.Header(fasthttp.HeaderLastModified); lastModified != "" {
+		header.Set(fasthttp.HeaderIfModifiedSince, lastModified)
+	}
+}
+
+func restoreRequestHeader(header *fasthttp.RequestHeader, key string, value []byte) {
+	if len(value) == 0 {
+		header.Del(key)
+		return
+	}
+	header.SetBytesV(key, value)
+}
+
+// refreshInBackground refetches a stale entry, or one refreshed early,
+// without holding up the client. Only one refresh per key runs at a time.
+// Like any other fetch, it reads no more of the body than the route may
+// cache; a longer one is dropped and the entry stays as it is.
+func (engine *HermyxEngine) refreshInBackground(cr *compiledRoute, key string, clientReq *fasthttp.Request, stale *cachemanager.CachedResponse) {
+	if _, running := engine.refreshing.LoadOrStore(key, struct{}{}); running {
+		engine.logger.Debug(fmt.Sprintf("Background refresh for key %s already in progress", key))
+		return
+	}
+
+	req := fasthttp.AcquireRequest()
+	clientReq.CopyTo(req)
+	if stale.HasValidators() {
+		setConditionalHeaders(&req.Header, stale)
+	} else {
+		req.Header.Del(fasthttp.HeaderIfNoneMatch)
+		req.Header.Del(fasthttp.HeaderIfModifiedSince)
+	}
+
+	engine.refreshes.Add(1)
+	go func() {
+		defer engine.refreshes.Done()
+		defer engine.refreshing.Delete(key)
+		defer fasthttp.ReleaseRequest(req)
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		started := time.Now()
+		if err := engine.fetchResponse(req, resp, cr.Route.Target, bufferLimit(cr)); err != nil {
+			engine.logger.Error(fmt.Sprintf("Background refresh for key %s failed: %v", key, err))
+			return
+		}
+
+		if resp.IsBodyStream() {
+			engine.logger.Info(fmt.Sprintf("Background refresh for key %s got a body past max cache size %d; discarding it", key, cr.Route.Cache.MaxContentSize))
+			resp.CloseBodyStream()
+			return
+		}
+
+		if resp.StatusCode() == fasthttp.StatusNotModified {
+			refreshTags(cr, &resp.Header, stale)
+			stale.Refresh(&resp.Header)
+			engine.refreshEntry(cr, key, &req.Header, stale)
+			engine.logger.Info(fmt.Sprintf("Background refresh confirmed entry for key %s", key))
+			return
+		}
+
+		engine.cacheResponse(cr, key, &req.Header, resp, time.Since(started))
+		engine.logger.Info(fmt.Sprintf("Background refresh fetched key %s", key))
+	}()
+}
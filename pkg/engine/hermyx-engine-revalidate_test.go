@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// staleConfig caches for 100ms and then keeps entries for the given stale
+// setting, e.g. "gracePeriod: 1m".
+func staleConfig(setting string) string {
+	return strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 100ms\n  "+setting+"\n", 1)
+}
+
+func TestExpiredEntryIsRevalidated(t *testing.T) {
+	var fetches, conditional atomic.Int32
+	proxy := newTestProxy(t, staleConfig("gracePeriod: 1m"), func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	})
+
+	proxy.get(t, "/x")
+	time.Sleep(150 * time.Millisecond)
+
+	resp := proxy.get(t, "/x")
+	if resp.status != http.StatusOK || resp.body != "body" {
+		t.Errorf("revalidated response = %d %q, want the stored body", resp.status, resp.body)
+	}
+	if got := resp.header.Get("X-Hermyx-Cache"); got != "REVALIDATED" {
+		t.Errorf("X-Hermyx-Cache = %q, want REVALIDATED", got)
+	}
+	if conditional.Load() != 1 {
+		t.Errorf("upstream saw %d conditional requests, want 1", conditional.Load())
+	}
+
+	if resp := proxy.get(t, "/x"); resp.header.Get("X-Hermyx-Cache") != "HIT" {
+		t.Errorf("refreshed entry was not fresh again: %q", resp.header.Get("X-Hermyx-Cache"))
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want 2", fetches.Load())
+	}
+}
+
+func TestExpiredEntryIsReplacedWhenChanged(t *testing.T) {
+	var version atomic.Int32
+	proxy := newTestProxy(t, staleConfig("gracePeriod: 1m"), func(w http.ResponseWriter, r *http.Request) {
+		body := []string{"v1", "v2"}[version.Add(1)-1]
+		w.Header().Set("ETag", `"`+body+`"`)
+		w.Write([]byte(body))
+	})
+
+	proxy.get(t, "/x")
+	time.Sleep(150 * time.Millisecond)
+
+	if resp := proxy.get(t, "/x"); resp.body != "v2" {
+		t.Errorf("body = %q, want the new version", resp.body)
+	}
+	if resp := proxy.get(t, "/x"); resp.body != "v2" || resp.header.Get("X-Hermyx-Cache") != "HIT" {
+		t.Errorf("got %q %q, want a hit on the new version", resp.body, resp.header.Get("X-Hermyx-Cache"))
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int32
+	proxy := newTestProxy(t, staleConfig("staleWhileRevalidate: 1m"), func(w http.ResponseWriter, r *http.Request) {
+		body := []string{"v1", "v2", "v3"}[version.Add(1)-1]
+		w.Write([]byte(body))
+	})
+
+	proxy.get(t, "/x")
+	time.Sleep(150 * time.Millisecond)
+
+	resp := proxy.get(t, "/x")
+	if resp.body != "v1" || resp.header.Get("X-Hermyx-Cache") != "STALE" {
+		t.Errorf("got %q %q, want the stale v1", resp.body, resp.header.Get("X-Hermyx-Cache"))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp := proxy.get(t, "/x")
+		if resp.body == "v2" && resp.header.Get("X-Hermyx-Cache") == "HIT" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("background refresh never landed; last got %q %q", resp.body, resp.header.Get("X-Hermyx-Cache"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var failing atomic.Bool
+	proxy := newTestProxy(t, staleConfig("staleIfError: 1m"), func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("good"))
+	})
+
+	proxy.get(t, "/x")
+	failing.Store(true)
+	time.Sleep(150 * time.Millisecond)
+
+	resp := proxy.get(t, "/x")
+	if resp.status != http.StatusOK || resp.body != "good" || resp.header.Get("X-Hermyx-Cache") != "STALE-IF-ERROR" {
+		t.Errorf("got %d %q %q, want the last good response", resp.status, resp.body, resp.header.Get("X-Hermyx-Cache"))
+	}
+
+	if resp := proxy.get(t, "/never-cached"); resp.status != http.StatusServiceUnavailable {
+		t.Errorf("uncached failure = %d, want the upstream 503", resp.status)
+	}
+}
+
+func TestBackgroundRefreshDropsBodiesTooLargeToCache(t *testing.T) {
+	large := strings.Repeat("v2 is too large to cache ", 100)
+
+	for _, chunked := range []bool{false, true} {
+		name := "with Content-Length"
+		if chunked {
+			name = "chunked"
+		}
+		t.Run(name, func(t *testing.T) {
+			config := strings.Replace(staleConfig("staleWhileRevalidate: 1m"), "  ttl: 100ms\n", "  ttl: 100ms\n  maxContentSize: 64\n", 1)
+
+			var fetches atomic.Int32
+			proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+				if fetches.Add(1) == 1 {
+					w.Write([]byte("v1"))
+					return
+				}
+				if chunked {
+					w.(http.Flusher).Flush()
+				}
+				w.Write([]byte(large))
+			})
+
+			proxy.get(t, "/x")
+			time.Sleep(150 * time.Millisecond)
+			proxy.get(t, "/x")
+
+			deadline := time.Now().Add(time.Second)
+			for fetches.Load() < 2 {
+				if time.Now().After(deadline) {
+					t.Fatal("background refresh never reached the upstream")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			resp := proxy.get(t, "/x")
+			if resp.body != "v1" || resp.header.Get("X-Hermyx-Cache") != "STALE" {
+				t.Errorf("got %q %q, want the stale v1 to stay", resp.body, resp.header.Get("X-Hermyx-Cache"))
+			}
+		})
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		fetches int32
+	}{
+		{name: "hot entry is refreshed before it expires", route: "cache: {enabled: true}", fetches: 2},
+		{name: "route turns it off", route: "cache: {enabled: true, earlyRefresh: 0}", fetches: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A slow fetch and a large earlyRefresh make a refresh all but
+			// certain on the first hits.
+			config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 2s\n  earlyRefresh: 1000\n", 1)
+			config = strings.Replace(config, "cache: {enabled: true}", tt.route, 1)
+
+			var fetches atomic.Int32
+			proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+				fetches.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				w.Write([]byte("ok"))
+			})
+
+			proxy.get(t, "/x")
+			for i := 0; i < 5; i++ {
+				if got := proxy.get(t, "/x").header.Get("X-Hermyx-Cache"); got != "HIT" {
+					t.Errorf("hit %d: X-Hermyx-Cache = %q, want HIT", i, got)
+				}
+			}
+
+			time.Sleep(200 * time.Millisecond)
+			if fetches.Load() != tt.fetches {
+				t.Errorf("upstream fetched %d times, want %d", fetches.Load(), tt.fetches)
+			}
+		})
+	}
+}
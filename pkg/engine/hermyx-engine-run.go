@@ -3,14 +3,20 @@ package engine
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"hermyx/pkg/cachemanager"
+	"hermyx/pkg/models"
+	"hermyx/pkg/utils/compress"
 	"hermyx/pkg/utils/fs"
 	"hermyx/pkg/utils/regex"
 
@@ -34,7 +40,39 @@ func (engine *HermyxEngine) compileRoutes() {
 			cr.ExcludeRegex = regex.CombinePattenrs(route.Exclude)
 		}
 
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i)
+		}
+
+		backend, err := engine.cacheManager.ResolveBackend(route.Name, engine.config.Cache, route.Cache)
+		if err != nil {
+			log.Fatalf("Unable to instantiate the cache for route %s: %v", route.Name, err)
+		}
+		if backend != cachemanager.DefaultBackend {
+			engine.logger.Info(fmt.Sprintf("Route %s uses its own %s cache backend", route.Name, route.Cache.Type))
+		}
+		cr.Backend = backend
+
 		route.Cache = engine.cacheManager.Resolve(engine.config.Cache, route.Cache)
+		if route.Cache.KeyConfig != nil {
+			keys, err := cachemanager.NewKeyBuilder(route.Name, cr.PathPattern, route.Cache.KeyConfig)
+			if err != nil {
+				log.Fatalf("Invalid cache key config for route %s: %v", route.Name, err)
+			}
+			if err := engine.cacheManager.LoadGeneration(cr.Backend, keys); err != nil {
+				engine.logger.Warn(fmt.Sprintf("Unable to load the cache generation of route %s: %v", route.Name, err))
+			}
+			cr.Keys = keys
+		}
+		if encoding := route.Cache.Compression; encoding != "" && encoding != compress.NONE && !compress.Supported(encoding) {
+			log.Fatalf("Unknown compression %q for route %s; use %q, %q, %q or %q", encoding, route.Name, compress.ZSTD, compress.GZIP, compress.BROTLI, compress.NONE)
+		}
+		if jitter := models.Setting(route.Cache.TtlJitter); jitter < 0 || jitter >= 1 {
+			log.Fatalf("Invalid ttlJitter %v for route %s; use a fraction from 0 up to, but not including, 1", jitter, route.Name)
+		}
+		if earlyRefresh := models.Setting(route.Cache.EarlyRefresh); earlyRefresh < 0 {
+			log.Fatalf("Invalid earlyRefresh %v for route %s; it cannot be negative", earlyRefresh, route.Name)
+		}
 		engine.compiledRoutes = append(engine.compiledRoutes, cr)
 	}
 }
@@ -59,6 +97,20 @@ func (engine *HermyxEngine) Run() {
 		}
 	}()
 
+	var adminServer *fasthttp.Server
+	if engine.config.Admin != nil {
+		adminServer = engine.newAdminServer()
+		adminAddr := engine.adminAddr()
+		engine.logger.Info(fmt.Sprintf("Hermyx admin API starting on %s...", adminAddr))
+
+		go func() {
+			if err := adminServer.ListenAndServe(adminAddr); err != nil {
+				engine.logger.Error(fmt.Sprintf("Fatal admin server error: %v", err))
+				os.Exit(1)
+			}
+		}()
+	}
+
 	err := engine.storePid()
 	if err != nil {
 		engine.logger.Error(fmt.Sprintf("Unable to store program information due to %v", err))
@@ -74,6 +126,11 @@ func (engine *HermyxEngine) Run() {
 	if err := server.Shutdown(); err != nil {
 		engine.logger.Error("Error during shutdown: " + err.Error())
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(); err != nil {
+			engine.logger.Error("Error during admin shutdown: " + err.Error())
+		}
+	}
 
 	err = engine.cleanup()
 	if err != nil {
@@ -89,6 +146,14 @@ func (engine *HermyxEngine) handleRequest(ctx *fasthttp.RequestCtx) {
 	method := strings.ToLower(string(ctx.Method()))
 	engine.logger.Info(fmt.Sprintf("Incoming request - Method: %s, Path: %s", method, path))
 
+	if engine.handlePurgeMethod(ctx, method) {
+		return
+	}
+
+	if unsafeMethods[method] {
+		defer engine.invalidateAfterUnsafe(ctx)
+	}
+
 	cr, matched := engine.matchRoute(path, method)
 	if !matched {
 		engine.logger.Info(fmt.Sprintf("No route matched for %s %s; proxying raw", method, path))
@@ -99,31 +164,139 @@ func (engine *HermyxEngine) handleRequest(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	if !cr.Route.Cache.Enabled {
+		if err := engine.proxyRequest(ctx, cr); err != nil {
+			engine.logger.Error(fmt.Sprintf("Proxy error for %s %s: %v", method, path, err))
+			ctx.Error("Proxy error: "+err.Error(), fasthttp.StatusBadGateway)
+			return
+		}
+		engine.compressForClient(ctx, cr)
+		return
+	}
+
 	if cr.Route.Cache.KeyConfig == nil {
 		engine.logger.Error("Cache KeyConfig is nil for route " + cr.Route.Path)
 		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
 		return
 	}
 
-	key := engine.cacheManager.GetKey(cr.Route.Cache.KeyConfig, ctx)
-	engine.logger.Debug(fmt.Sprintf("Cache key generated: %s", key))
+	if bodyExceedsKeyLimit(cr, &ctx.Request) {
+		engine.logger.Info(fmt.Sprintf("Request body of %s %s exceeds the cache key limit; proxying without the cache", method, path))
+		if err := engine.fetchUpstream(ctx, cr.Route.Target, 0); err != nil {
+			engine.logger.Error(fmt.Sprintf("Proxy error for %s %s: %v", method, path, err))
+			ctx.Error("Proxy error: "+err.Error(), fasthttp.StatusBadGateway)
+			return
+		}
+		engine.compressForClient(ctx, cr)
+		return
+	}
+
+	// Only a GET the cache may store is widened to the full object; any
+	// other request keeps its Range for the upstream to answer. This happens
+	// before the key is built, as it changes the request's Accept-Encoding.
+	var ranged *rangeRequest
+	if ctx.IsGet() {
+		ranged = takeRange(&ctx.Request.Header)
+		defer engine.applyRange(ctx, ranged)
+	}
+
+	key, readableKey := cr.Keys.Build(ctx)
+	engine.logger.Debug(fmt.Sprintf("Cache key generated: %s (%s)", key, readableKey))
+	if cr.Route.Cache.KeyConfig.DebugHeader {
+		defer setKeyHeaders(ctx, key, readableKey)
+	}
 
-	if cr.Route.Cache.Enabled {
-		if hit := engine.handleCache(ctx, cr, key); hit {
+	if keysOnEncoding(cr) {
+		normalizeAcceptEncoding(&ctx.Request.Header)
+	}
+
+	hit, stale := engine.handleCache(ctx, cr, key)
+	if hit {
+		return
+	}
+
+	if stale != nil && mayServeStale(cr, stale, models.Setting(cr.Route.Cache.StaleWhileRevalidate)) {
+		engine.logger.Info(fmt.Sprintf("Serving stale entry for key %s while it is refreshed", key))
+		engine.serveCached(ctx, cr, stale, "STALE")
+		engine.refreshInBackground(cr, key, &ctx.Request, stale)
+		return
+	}
+
+	if !models.Setting(cr.Route.Cache.DisableCoalescing) {
+		fetch, leader := engine.joinFetch(key)
+		if leader {
+			defer engine.finishFetch(key, fetch)
+		} else if hit, stale = engine.awaitFetch(ctx, cr, key, fetch, stale); hit {
 			return
 		}
 	}
 
-	if err := engine.proxyRequest(ctx, cr); err != nil {
+	revalidating := stale != nil && stale.HasValidators()
+
+	var err error
+	started := time.Now()
+	if revalidating {
+		err = engine.proxyRevalidation(ctx, cr, key, stale)
+	} else {
+		err = engine.proxyRequest(ctx, cr)
+	}
+	fetchDuration := time.Since(started)
+
+	if stale != nil && upstreamFailed(ctx, err) && mayServeStale(cr, stale, models.Setting(cr.Route.Cache.StaleIfError)) {
+		engine.logger.Warn(fmt.Sprintf("Upstream failed for %s %s; serving stale entry for key %s", method, path, key))
+		ctx.Response.Reset()
+		engine.serveCached(ctx, cr, stale, "STALE-IF-ERROR")
+		return
+	}
+
+	if err != nil {
 		engine.logger.Error(fmt.Sprintf("Proxy error for %s %s: %v", method, path, err))
 		ctx.Error("Proxy error: "+err.Error(), fasthttp.StatusBadGateway)
 		return
 	}
 
-	engine.cacheResponse(cr, key, ctx)
+	if revalidating && ctx.Response.StatusCode() == fasthttp.StatusNotModified {
+		engine.confirmStale(ctx, cr, key, stale)
+		return
+	}
+
+	if ranged != nil && tooLargeToCache(cr, &ctx.Response) {
+		engine.proxyRange(ctx, cr, ranged)
+		return
+	}
+
+	engine.cacheResponse(cr, key, &ctx.Request.Header, &ctx.Response, fetchDuration)
+	engine.compressForClient(ctx, cr)
+}
+
+// upstreamFailed reports whether the upstream could not produce a usable
+// response: the request itself failed or the origin answered with a 5xx.
+func upstreamFailed(ctx *fasthttp.RequestCtx, err error) bool {
+	return err != nil || ctx.Response.StatusCode() >= fasthttp.StatusInternalServerError
 }
 
 func (engine *HermyxEngine) matchRoute(path, method string) (*compiledRoute, bool) {
+	cr := engine.matchPath(path)
+	if cr == nil {
+		return nil, false
+	}
+
+	// Check excluded methods
+	if cr.Route.Cache.KeyConfig != nil {
+		for _, excludedMethod := range cr.Route.Cache.KeyConfig.ExcludeMethods {
+			if strings.ToLower(excludedMethod) == method {
+				engine.logger.Info(fmt.Sprintf("Request method %s excluded for route %s", method, cr.Route.Path))
+				return nil, false
+			}
+		}
+	}
+
+	return cr, true
+}
+
+// matchPath returns the first route whose patterns accept the path,
+// regardless of the request method.
+func (engine *HermyxEngine) matchPath(path string) *compiledRoute {
 	for i := range engine.compiledRoutes {
 		cr := &engine.compiledRoutes[i]
 
@@ -142,63 +315,253 @@ func (engine *HermyxEngine) matchRoute(path, method string) (*compiledRoute, boo
 			continue
 		}
 
-		// Check excluded methods
-		if cr.Route.Cache.KeyConfig != nil {
-			for _, excludedMethod := range cr.Route.Cache.KeyConfig.ExcludeMethods {
-				if strings.ToLower(excludedMethod) == method {
-					engine.logger.Info(fmt.Sprintf("Request method %s excluded for route %s", method, cr.Route.Path))
-					return nil, false
-				}
-			}
-		}
-
-		return cr, true
+		return cr
 	}
-	return nil, false
+	return nil
 }
 
-func (engine *HermyxEngine) handleCache(ctx *fasthttp.RequestCtx, cr *compiledRoute, key string) bool {
-	res, exists, err := engine.cacheManager.Get(key)
+// handleCache serves the cached response for key when it is fresh. An entry
+// that is held past its freshness lifetime is returned instead so that the
+// caller can revalidate it with the upstream.
+func (engine *HermyxEngine) handleCache(ctx *fasthttp.RequestCtx, cr *compiledRoute, key string) (bool, *cachemanager.CachedResponse) {
+	baseKey := key
+	res, exists, err := engine.cacheManager.Get(cr.Backend, key)
 	if err != nil {
 		engine.logger.Error(fmt.Sprintf("Error while accessing the cache: %s", err.Error()))
-		return false
+		return false, nil
+	}
+
+	if exists && res.IsVaryMarker() {
+		key = cr.Keys.VariantKey(key, res.Vary, &ctx.Request.Header)
+		engine.logger.Debug(fmt.Sprintf("Response varies on %v; looking up variant key %s", res.Vary, key))
+
+		res, exists, err = engine.cacheManager.Get(cr.Backend, key)
+		if err != nil {
+			engine.logger.Error(fmt.Sprintf("Error while accessing the cache: %s", err.Error()))
+			return false, nil
+		}
+	}
+
+	if !exists {
+		engine.logger.Info(fmt.Sprintf("Cache MISS for key %s (path %s)", key, string(ctx.Path())))
+		return false, nil
+	}
+
+	if engine.banned(string(ctx.RequestURI()), res.StoredAt) {
+		engine.logger.Info(fmt.Sprintf("Cache entry for key %s is banned; dropping it", key))
+		engine.cacheManager.Delete(cr.Backend, key)
+		return false, nil
+	}
+
+	if !res.IsFresh() {
+		engine.logger.Info(fmt.Sprintf("Cache STALE for key %s (path %s)", key, string(ctx.Path())))
+		return false, res
+	}
+
+	engine.logger.Info(fmt.Sprintf("Cache HIT for key %s (path %s)", key, string(ctx.Path())))
+	engine.serveCached(ctx, cr, res, "HIT")
+
+	if res.RefreshEarly(models.Setting(cr.Route.Cache.EarlyRefresh)) {
+		engine.logger.Info(fmt.Sprintf("Refreshing key %s ahead of its expiry in %s", key, time.Until(res.ExpiresAt).Round(time.Millisecond)))
+		engine.refreshInBackground(cr, baseKey, &ctx.Request, res)
+	}
+	return true, nil
+}
+
+func (engine *HermyxEngine) serveCached(ctx *fasthttp.RequestCtx, cr *compiledRoute, res *cachemanager.CachedResponse, cacheStatus string) {
+	if res.NotModified(&ctx.Request.Header) {
+		engine.logger.Debug(fmt.Sprintf("Client copy of %s is current; answering 304", string(ctx.Path())))
+		res.WriteNotModifiedTo(&ctx.Response)
+	} else {
+		if err := res.WriteTo(&ctx.Response, ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)); err != nil {
+			engine.logger.Error(fmt.Sprintf("Unable to serve cached entry for %s: %v", string(ctx.Path()), err))
+			ctx.Response.Reset()
+			ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+			return
+		}
+		engine.compressForClient(ctx, cr)
 	}
 
-	if exists {
-		engine.logger.Info(fmt.Sprintf("Cache HIT for key %s (path %s)", key, string(ctx.Path())))
-		ctx.SetStatusCode(fasthttp.StatusOK)
-		ctx.Response.Header.Set("X-Hermyx-Cache", "HIT")
-		ctx.SetBody(res)
-		return true
+	if models.Setting(cr.Route.Cache.RespectOriginHeaders) {
+		age := int64(res.Age().Seconds())
+		if upstreamAge, err := strconv.ParseInt(res.Header(fasthttp.HeaderAge), 10, 64); err == nil {
+			age += upstreamAge
+		}
+		ctx.Response.Header.Set(fasthttp.HeaderAge, strconv.FormatInt(age, 10))
 	}
-	engine.logger.Info(fmt.Sprintf("Cache MISS for key %s (path %s)", key, string(ctx.Path())))
-	return false
+	ctx.Response.Header.Set("X-Hermyx-Cache", cacheStatus)
 }
 
 func (engine *HermyxEngine) proxyRequest(ctx *fasthttp.RequestCtx, cr *compiledRoute) error {
 	target := cr.Route.Target
-	client := engine.getClientForTarget(target)
 
 	engine.logger.Info(fmt.Sprintf("Proxying request %s %s to backend %s", string(ctx.Method()), string(ctx.Path()), target))
-	return client.Do(&ctx.Request, &ctx.Response)
+	return engine.fetchUpstream(ctx, target, bufferLimit(cr))
 }
 
-func (engine *HermyxEngine) cacheResponse(cr *compiledRoute, key string, ctx *fasthttp.RequestCtx) {
-	status := ctx.Response.StatusCode()
-	if status >= 200 && status < 300 {
-		body := ctx.Response.Body()
-		if uint64(len(body)) <= cr.Route.Cache.MaxContentSize {
-			cacheTtl := cr.Route.Cache.Ttl
-			engine.cacheManager.Set(key, append([]byte(nil), body...), cacheTtl)
-			engine.logger.Info(fmt.Sprintf("Cached response for key %s with TTL %s", key, cacheTtl.String()))
-		} else {
-			engine.logger.Info(fmt.Sprintf("Response size %d exceeds max cache size %d; skipping cache for key %s", len(body), cr.Route.Cache.MaxContentSize, key))
+func (engine *HermyxEngine) cacheResponse(cr *compiledRoute, key string, reqHeader *fasthttp.RequestHeader, resp *fasthttp.Response, fetchDuration time.Duration) {
+	tags := takeSurrogateKeys(cr, &resp.Header)
+
+	statusTtl, ok := routeStatusTtl(cr.Route.Cache, resp.StatusCode())
+	if !ok {
+		engine.logger.Debug(fmt.Sprintf("Not caching response for key %s due to status %d", key, resp.StatusCode()))
+		return
+	}
+
+	if resp.IsBodyStream() {
+		engine.logger.Info(fmt.Sprintf("Response is streamed past max cache size %d; skipping cache for key %s", cr.Route.Cache.MaxContentSize, key))
+		return
+	}
+
+	body := resp.Body()
+	if uint64(len(body)) > cr.Route.Cache.MaxContentSize {
+		engine.logger.Info(fmt.Sprintf("Response size %d exceeds max cache size %d; skipping cache for key %s", len(body), cr.Route.Cache.MaxContentSize, key))
+		return
+	}
+
+	cacheTtl, vary, ok := engine.cachePolicy(cr, key, statusTtl, reqHeader, &resp.Header)
+	if !ok {
+		return
+	}
+
+	if models.Setting(cr.Route.Cache.GenerateEtag) && len(resp.Header.Peek(fasthttp.HeaderETag)) == 0 {
+		resp.Header.Set(fasthttp.HeaderETag, cachemanager.GenerateETag(body))
+	}
+
+	res := cachemanager.NewCachedResponse(resp)
+	res.Tags = tags
+	res.FetchDuration = fetchDuration
+	engine.storeResponse(cr, key, reqHeader, res, cacheTtl, vary)
+}
+
+const defaultSurrogateKeyHeader = "Surrogate-Key"
+
+// takeSurrogateKeys removes the route's surrogate-key header from an
+// upstream response, so it never reaches clients, and returns its tags.
+func takeSurrogateKeys(cr *compiledRoute, header *fasthttp.ResponseHeader) []string {
+	name := cr.Route.Cache.SurrogateKeyHeader
+	if name == "" {
+		name = defaultSurrogateKeyHeader
+	}
+
+	tags := cachemanager.ParseSurrogateKeys(string(header.Peek(name)))
+	header.Del(name)
+	return tags
+}
+
+// routeStatusTtl returns how long a response with the status stays fresh on
+// the route: the statusTtl entry for its code or class, or the route TTL for
+// any other 2xx. Partial and 304 answers only make sense for the request
+// that got them and are never stored.
+func routeStatusTtl(config *models.CacheConfig, status int) (time.Duration, bool) {
+	if status < 200 || status == fasthttp.StatusPartialContent || status == fasthttp.StatusNotModified {
+		return 0, false
+	}
+	if ttl, ok := config.StatusTtl.Lookup(status); ok {
+		return ttl, ttl > 0
+	}
+	return config.Ttl, status < 300
+}
+
+// cachePolicy works out how long a response with the given headers stays
+// fresh on the route and which request headers select its variant. ttl is
+// the route's TTL for the response status.
+func (engine *HermyxEngine) cachePolicy(cr *compiledRoute, key string, ttl time.Duration, reqHeader *fasthttp.RequestHeader, header *fasthttp.ResponseHeader) (time.Duration, []string, bool) {
+	cacheTtl := ttl
+	var vary []string
+
+	if models.Setting(cr.Route.Cache.RespectOriginHeaders) {
+		policy := cachemanager.ParseOriginPolicy(reqHeader, header, time.Now())
+		if !policy.Storable {
+			engine.logger.Debug(fmt.Sprintf("Not caching response for key %s: upstream forbids storing it", key))
+			return 0, nil, false
 		}
-	} else {
-		engine.logger.Debug(fmt.Sprintf("Not caching response for key %s due to status %d", key, status))
+		if policy.HasFreshness {
+			cacheTtl = policy.Ttl
+		}
+		vary = withoutEncodingVary(cr, policy.Vary)
+	}
+
+	// Without a grace period a response that is never fresh is useless; with
+	// one it is kept so that every reuse revalidates it.
+	if cacheTtl <= 0 && staleRetention(cr.Route.Cache) <= 0 {
+		engine.logger.Debug(fmt.Sprintf("Not caching response for key %s: it has no freshness lifetime", key))
+		return 0, nil, false
+	}
+
+	return max(cacheTtl, 0), vary, true
+}
+
+func (engine *HermyxEngine) storeResponse(cr *compiledRoute, key string, reqHeader *fasthttp.RequestHeader, res *cachemanager.CachedResponse, cacheTtl time.Duration, vary []string) {
+	stale := staleRetention(cr.Route.Cache)
+
+	if models.Setting(cr.Route.Cache.InvalidateOnUnsafe) {
+		res.Tags = withPathTag(res.Tags, reqHeader)
+	}
+
+	if encoding := cr.Route.Cache.Compression; encoding != "" && encoding != compress.NONE {
+		if err := res.Compress(encoding, compressionMinSize(cr.Route.Cache)); err != nil {
+			engine.logger.Warn(fmt.Sprintf("Unable to compress response for key %s; storing it uncompressed: %v", key, err))
+		}
+	}
+
+	if len(vary) > 0 {
+		// The marker is not jittered so that it outlives the variants it
+		// points at.
+		if err := engine.cacheManager.Set(cr.Backend, key, cachemanager.NewVaryMarker(vary), cacheTtl, stale, 0); err != nil {
+			engine.logger.Error(fmt.Sprintf("Unable to cache vary marker for key %s: %v", key, err))
+			return
+		}
+		key = cr.Keys.VariantKey(key, vary, reqHeader)
+	}
+
+	if err := engine.cacheManager.Set(cr.Backend, key, res, cacheTtl, stale, models.Setting(cr.Route.Cache.TtlJitter)); err != nil {
+		engine.logger.Error(fmt.Sprintf("Unable to cache response for key %s: %v", key, err))
+		return
+	}
+	engine.logger.Info(fmt.Sprintf("Cached response for key %s with TTL %s", key, cacheTtl.String()))
+	engine.noteLifetime(cacheTtl + stale)
+}
+
+// setKeyHeaders exposes the cache key of the request, and its readable form
+// when the key is hashed, for debugging.
+func setKeyHeaders(ctx *fasthttp.RequestCtx, key, readableKey string) {
+	ctx.Response.Header.Set("X-Hermyx-Cache-Key", key)
+	if readableKey != key {
+		ctx.Response.Header.Set("X-Hermyx-Cache-Key-Source", readableKey)
 	}
 }
 
+// defaultMaxKeyBodySize bounds the request bodies the body key part hashes
+// when the key config sets no maxBodySize.
+const defaultMaxKeyBodySize = 64 * 1024
+
+// bodyExceedsKeyLimit reports whether a route keyed on the request body got
+// a body too large to hash; such requests bypass the cache.
+func bodyExceedsKeyLimit(cr *compiledRoute, req *fasthttp.Request) bool {
+	keyConfig := cr.Route.Cache.KeyConfig
+	if !slices.Contains(keyConfig.Type, models.CACHE_KEY_BODY) {
+		return false
+	}
+
+	limit := keyConfig.MaxBodySize
+	if limit == 0 {
+		limit = defaultMaxKeyBodySize
+	}
+	return uint64(len(req.Body())) > limit
+}
+
+// defaultCompressionMinSize keeps small bodies, which barely shrink, from
+// paying the cost of compression.
+const defaultCompressionMinSize = 1024
+
+func compressionMinSize(config *models.CacheConfig) int {
+	if config.CompressionMinSize == 0 {
+		return defaultCompressionMinSize
+	}
+	return int(config.CompressionMinSize)
+}
+
 func (engine *HermyxEngine) fallbackProxy(ctx *fasthttp.RequestCtx) error {
 	host := string(ctx.Host())
 	if host == "" {
@@ -207,9 +570,8 @@ func (engine *HermyxEngine) fallbackProxy(ctx *fasthttp.RequestCtx) error {
 		return nil
 	}
 
-	client := engine.getClientForTarget(host)
 	engine.logger.Info(fmt.Sprintf("Fallback proxying to %s", host))
-	return client.Do(&ctx.Request, &ctx.Response)
+	return engine.fetchUpstream(ctx, host, 0)
 }
 
 func (engine *HermyxEngine) storePid() error {
@@ -238,9 +600,16 @@ func (engine *HermyxEngine) storePid() error {
 func (engine *HermyxEngine) cleanup() error {
 	var err error = nil
 
+	// Background refreshes still write to the cache.
+	engine.refreshes.Wait()
+
+	for name, usage := range engine.cacheManager.Usage() {
+		engine.logger.Info(fmt.Sprintf("Cache backend %s held %d entries using %d bytes", name, usage.Entries, usage.Bytes))
+	}
+
 	err = engine.cacheManager.Close()
 	if err != nil {
-		engine.logger.Error(fmt.Sprintf("Failed to close the cache due to: %w", err))
+		engine.logger.Error(fmt.Sprintf("Failed to close the cache due to: %v", err))
 	}
 	engine.logger.Info("Cache closed")
 
@@ -0,0 +1,395 @@
+package engine
+
+import (
+	"hermyx/pkg/cachemanager"
+	"hermyx/pkg/models"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReplaysFullResponseOnHit(t *testing.T) {
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, testConfig, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	})
+
+	miss := proxy.get(t, "/items")
+	hit := proxy.get(t, "/items")
+
+	if fetches.Load() != 1 {
+		t.Fatalf("upstream fetched %d times, want 1", fetches.Load())
+	}
+	if got := hit.header.Get("X-Hermyx-Cache"); got != "HIT" {
+		t.Errorf("X-Hermyx-Cache = %q, want HIT", got)
+	}
+	for _, resp := range []testResponse{miss, hit} {
+		if resp.status != http.StatusCreated || resp.body != `{"id":1}` {
+			t.Errorf("response = %d %q, want 201 with the upstream body", resp.status, resp.body)
+		}
+		if got := resp.header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		if got := resp.header.Values("X-Multi"); len(got) != 2 {
+			t.Errorf("X-Multi = %v, want both values", got)
+		}
+	}
+	if got := hit.header.Get("Set-Cookie"); got != "" {
+		t.Errorf("hit replayed Set-Cookie %q", got)
+	}
+}
+
+func TestMustRevalidateIsNeverServedStale(t *testing.T) {
+	for _, setting := range []string{"staleWhileRevalidate: 1m", "staleIfError: 1m"} {
+		t.Run(setting, func(t *testing.T) {
+			var failing atomic.Bool
+			config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 100ms\n  "+setting+"\n  respectOriginHeaders: true\n", 1)
+			proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=0, must-revalidate")
+				if failing.Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("down"))
+					return
+				}
+				w.Write([]byte("good"))
+			})
+
+			proxy.get(t, "/x")
+			failing.Store(true)
+
+			resp := proxy.get(t, "/x")
+			if resp.status != http.StatusServiceUnavailable || resp.header.Get("X-Hermyx-Cache") != "" {
+				t.Errorf("got %d %q, want the upstream answer", resp.status, resp.header.Get("X-Hermyx-Cache"))
+			}
+		})
+	}
+}
+
+func TestQueryStringsAreCachedSeparately(t *testing.T) {
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, testConfig, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(r.URL.RawQuery))
+	})
+
+	for _, path := range []string{"/q?a=1", "/q?a=2", "/q?a=1"} {
+		if resp := proxy.get(t, path); resp.body != path[3:] {
+			t.Errorf("GET %s body = %q", path, resp.body)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want 2", fetches.Load())
+	}
+}
+
+const originConfig = `
+log: {toStdout: false}
+server: {port: 1}
+storage: {path: STORAGE}
+cache:
+  type: memory
+  enabled: true
+  ttl: 1m
+  respectOriginHeaders: true
+  keyConfig: {type: [method, path]}
+routes:
+  - name: api
+    path: "^/"
+    target: UPSTREAM
+    cache: {enabled: true}
+`
+
+func TestOriginHeadersDecideWhatIsCached(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		request      map[string]string
+		fetches      int32
+	}{
+		{name: "max-age", cacheControl: "max-age=60", fetches: 1},
+		{name: "no-store", cacheControl: "no-store", fetches: 2},
+		{name: "private", cacheControl: "private, max-age=60", fetches: 2},
+		{name: "already aged out", cacheControl: "max-age=60", request: map[string]string{"X-Age": "60"}, fetches: 2},
+		{name: "authorization", cacheControl: "max-age=60", request: map[string]string{"Authorization": "Bearer t"}, fetches: 2},
+		{name: "authorization public", cacheControl: "public, max-age=60", request: map[string]string{"Authorization": "Bearer t"}, fetches: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			proxy := newTestProxy(t, originConfig, func(w http.ResponseWriter, r *http.Request) {
+				fetches.Add(1)
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				if age := r.Header.Get("X-Age"); age != "" {
+					w.Header().Set("Age", age)
+				}
+				w.Write([]byte("body"))
+			})
+
+			proxy.do(t, http.MethodGet, "/x", tt.request)
+			proxy.do(t, http.MethodGet, "/x", tt.request)
+			if fetches.Load() != tt.fetches {
+				t.Errorf("upstream fetched %d times, want %d", fetches.Load(), tt.fetches)
+			}
+		})
+	}
+}
+
+func TestVaryKeepsVariantsApart(t *testing.T) {
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, originConfig, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+
+	for _, language := range []string{"en", "de", "en", "de"} {
+		resp := proxy.do(t, http.MethodGet, "/x", map[string]string{"Accept-Language": language})
+		if resp.body != language {
+			t.Errorf("Accept-Language %s got %q", language, resp.body)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want 2", fetches.Load())
+	}
+}
+
+func TestConditionalHitAnswersNotModified(t *testing.T) {
+	var fetches atomic.Int32
+	config := strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 1m\n  statusTtl: {404: 1m}\n", 1)
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("body"))
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		etag   string
+		status int
+	}{
+		{name: "current copy", path: "/x", etag: `"v1"`, status: http.StatusNotModified},
+		{name: "outdated copy", path: "/x", etag: `"v0"`, status: http.StatusOK},
+		{name: "cached error", path: "/missing", etag: `"v1"`, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy.get(t, tt.path)
+			resp := proxy.do(t, http.MethodGet, tt.path, map[string]string{"If-None-Match": tt.etag})
+			if resp.status != tt.status {
+				t.Errorf("status = %d, want %d", resp.status, tt.status)
+			}
+			if tt.status == http.StatusNotModified && resp.body != "" {
+				t.Errorf("304 carried body %q", resp.body)
+			}
+		})
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want 2", fetches.Load())
+	}
+}
+
+func TestSurrogateKeysAreNotForwarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		header string
+	}{
+		{name: "default header", config: testConfig, header: "Surrogate-Key"},
+		{name: "custom header", config: strings.Replace(testConfig, "  ttl: 1m\n", "  ttl: 1m\n  surrogateKeyHeader: Cache-Tag\n", 1), header: "Cache-Tag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t, tt.config, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(tt.header, "product-1 category-2")
+				w.Write([]byte("body"))
+			})
+
+			for _, resp := range []testResponse{proxy.get(t, "/x"), proxy.get(t, "/x")} {
+				if got := resp.header.Get(tt.header); got != "" {
+					t.Errorf("%s reached the client: %q", tt.header, got)
+				}
+			}
+			if purged, err := proxy.engine.cacheManager.PurgeTag("category-2"); err != nil || purged != 1 {
+				t.Errorf("PurgeTag = %d, %v, want the tagged entry", purged, err)
+			}
+		})
+	}
+}
+
+func TestBodyKeyPart(t *testing.T) {
+	config := strings.Replace(testConfig, "cache: {enabled: true}",
+		"cache: {enabled: true, keyConfig: {type: [method, path, body], maxBodySize: 8}}", 1)
+
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	tests := []struct {
+		body  string
+		cache string
+	}{
+		{body: "a", cache: ""},
+		{body: "a", cache: "HIT"},
+		{body: "b", cache: ""},
+		{body: "too large body", cache: ""},
+		{body: "too large body", cache: ""},
+	}
+	for _, tt := range tests {
+		resp := proxy.send(t, http.MethodGet, "/search", nil, tt.body)
+		if resp.body != tt.body {
+			t.Errorf("body %q: got %q", tt.body, resp.body)
+		}
+		if got := resp.header.Get("X-Hermyx-Cache"); got != tt.cache {
+			t.Errorf("body %q: X-Hermyx-Cache = %q, want %q", tt.body, got, tt.cache)
+		}
+	}
+	if fetches.Load() != 4 {
+		t.Errorf("upstream fetched %d times, want 4", fetches.Load())
+	}
+}
+
+func TestNormalizedQueriesShareAnEntry(t *testing.T) {
+	config := strings.Replace(testConfig, "cache: {enabled: true}",
+		"cache: {enabled: true, keyConfig: {type: [path, query], query: {sort: true, exclude: [\"utm_.*\"]}}}", 1)
+
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/list?b=2&a=1", "/list?a=1&b=2", "/list?a=1&utm_source=mail&b=2"} {
+		proxy.get(t, path)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("upstream fetched %d times, want 1", fetches.Load())
+	}
+	if got := proxy.get(t, "/list?a=2&b=2").header.Get("X-Hermyx-Cache"); got == "HIT" {
+		t.Error("a different query value hit the normalized entry")
+	}
+}
+
+func TestHashedKeysKeepVariantsApart(t *testing.T) {
+	config := strings.Replace(testConfig, "cache: {enabled: true}",
+		"cache: {enabled: true, respectOriginHeaders: true, keyConfig: {type: [path], hash: sha256}}", 1)
+
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Vary", "X-User")
+		w.Write([]byte(r.Header.Get("X-User")))
+	})
+
+	for _, user := range []string{"alice", "bob", "alice", "bob"} {
+		if resp := proxy.do(t, http.MethodGet, "/me", map[string]string{"X-User": user}); resp.body != user {
+			t.Errorf("X-User %s got %q", user, resp.body)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want one per variant", fetches.Load())
+	}
+
+	backend, err := proxy.engine.cacheManager.Backend(cachemanager.DefaultBackend, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Enumerate(func(key string, value []byte, ttl time.Duration) error {
+		if strings.Contains(key, "alice") || strings.Contains(key, "bob") {
+			t.Errorf("stored key %q holds a raw header value", key)
+		}
+		return nil
+	})
+}
+
+func TestRouteStatusTtl(t *testing.T) {
+	config := &models.CacheConfig{
+		Ttl:       time.Minute,
+		StatusTtl: models.StatusTtl{"200": time.Hour, "301": time.Hour, "404": 30 * time.Second, "4xx": 0, "206": time.Hour},
+	}
+
+	tests := []struct {
+		status int
+		want   time.Duration
+		ok     bool
+	}{
+		{status: 200, want: time.Hour, ok: true},
+		{status: 204, want: time.Minute, ok: true},
+		{status: 301, want: time.Hour, ok: true},
+		{status: 302, ok: false},
+		{status: 404, want: 30 * time.Second, ok: true},
+		{status: 410, ok: false},
+		{status: 500, ok: false},
+		{status: 206, ok: false},
+		{status: 304, ok: false},
+		{status: 101, ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := routeStatusTtl(config, tt.status)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("routeStatusTtl(%d) = %s, %v, want %s, %v", tt.status, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStatusTtlCachesRedirectsAndNotFound(t *testing.T) {
+	config := strings.Replace(testConfig, "ttl: 1m", "ttl: 1m\n  statusTtl: {301: 1h, 404: 30s, 5xx: 0}", 1)
+
+	var fetches atomic.Int32
+	proxy := newTestProxy(t, config, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		cached bool
+	}{
+		{path: "/moved", status: http.StatusMovedPermanently, cached: true},
+		{path: "/missing", status: http.StatusNotFound, cached: true},
+		{path: "/broken", status: http.StatusBadGateway, cached: false},
+	}
+	for _, tt := range tests {
+		proxy.get(t, tt.path)
+		resp := proxy.get(t, tt.path)
+		if resp.status != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.status, tt.status)
+		}
+		if hit := resp.header.Get("X-Hermyx-Cache") == "HIT"; hit != tt.cached {
+			t.Errorf("GET %s hit the cache: %v, want %v", tt.path, hit, tt.cached)
+		}
+		if tt.status == http.StatusMovedPermanently && resp.header.Get("Location") != "/new" {
+			t.Errorf("cached redirect lost its Location: %q", resp.header.Get("Location"))
+		}
+	}
+	if fetches.Load() != 4 {
+		t.Errorf("upstream fetched %d times, want 4", fetches.Load())
+	}
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valyala/fasthttp"
+)
+
+// errBodyNotDrained closes an upstream connection whose body was abandoned
+// half-read, so that the rest of it is never taken for the next response.
+var errBodyNotDrained = errors.New("upstream body was not read to the end")
+
+// upstreamBody is an upstream body on its way to the client. It holds the
+// pooled upstream response, and with it the connection, until the server
+// has written the body out.
+type upstreamBody struct {
+	io.Reader
+	upstream *fasthttp.Response
+	done     bool
+}
+
+func (body *upstreamBody) Read(p []byte) (int, error) {
+	n, err := body.Reader.Read(p)
+	if err == io.EOF {
+		body.done = true
+	}
+	return n, err
+}
+
+func (body *upstreamBody) CloseWithError(err error) error {
+	if err != nil || !body.done {
+		// The connection still carries the rest of the body; fasthttp
+		// closes it rather than reuse it.
+		body.upstream.SetConnectionClose()
+	}
+	fasthttp.ReleaseResponse(body.upstream)
+	return nil
+}
+
+// getStreamingClientForTarget returns the HostClient for target that hands
+// response bodies over as streams, leaving it to the engine to decide which
+// ones to read into memory.
+func (engine *HermyxEngine) getStreamingClientForTarget(target string) *fasthttp.HostClient {
+	addr := targetAddr(target)
+
+	engine.clientsMu.Lock()
+	defer engine.clientsMu.Unlock()
+
+	if client, ok := engine.streamClients[addr]; ok {
+		return client
+	}
+
+	client := &fasthttp.HostClient{
+		Addr:               addr,
+		MaxConns:           10000, // Tune this
+		StreamResponseBody: true,
+		// Any body with a Content-Length above this is streamed rather than
+		// read by fasthttp; bodies of unknown length always are.
+		MaxResponseBodySize: 1,
+	}
+	engine.streamClients[addr] = client
+	return client
+}
+
+// bufferLimit is the largest upstream body read into memory for the route:
+// the largest one it may cache. Routes that do not cache stream every body.
+func bufferLimit(cr *compiledRoute) int {
+	if !cr.Route.Cache.Enabled {
+		return 0
+	}
+	return int(cr.Route.Cache.MaxContentSize)
+}
+
+// fetchUpstream proxies the request to target. A body of up to limit bytes
+// is read into the response, where the cache can take it; a longer one is
+// streamed to the client as it arrives. When the upstream does not announce
+// the length, the body is read until it passes limit and then cut over to
+// streaming with what was read so far in front.
+func (engine *HermyxEngine) fetchUpstream(ctx *fasthttp.RequestCtx, target string, limit int) error {
+	return engine.fetchResponse(&ctx.Request, &ctx.Response, target, limit)
+}
+
+// fetchResponse is fetchUpstream for a request that does not come from a
+// client connection, such as a background refresh.
+func (engine *HermyxEngine) fetchResponse(req *fasthttp.Request, resp *fasthttp.Response, target string, limit int) error {
+	client := engine.getStreamingClientForTarget(target)
+
+	upstream := fasthttp.AcquireResponse()
+	if err := client.Do(req, upstream); err != nil {
+		fasthttp.ReleaseResponse(upstream)
+		return err
+	}
+
+	resp.ResetBody()
+	upstream.Header.CopyTo(&resp.Header)
+
+	stream, ok := upstream.BodyStream().(fasthttp.ReadCloserWithError)
+	if !ok {
+		resp.SetBody(upstream.Body())
+		fasthttp.ReleaseResponse(upstream)
+		return nil
+	}
+
+	body := &upstreamBody{Reader: stream, upstream: upstream}
+	size := upstream.Header.ContentLength()
+	if limit == 0 || size > limit {
+		engine.logger.Debug(fmt.Sprintf("Streaming response body of %s to the client", string(req.URI().Path())))
+		resp.SetBodyStream(body, size)
+		return nil
+	}
+
+	prefix, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	if err != nil {
+		body.CloseWithError(err)
+		return err
+	}
+
+	if len(prefix) <= limit {
+		body.CloseWithError(nil)
+		resp.SetBody(prefix)
+		return nil
+	}
+
+	engine.logger.Debug(fmt.Sprintf("Response body of %s passed %d bytes; streaming the rest", string(req.URI().Path()), limit))
+	body.Reader = io.MultiReader(bytes.NewReader(prefix), stream)
+	resp.SetBodyStream(body, -1)
+	return nil
+}
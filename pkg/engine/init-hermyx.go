@@ -51,7 +51,7 @@ func InitConfig(configPath string) error {
 				ExcludeMethods: []string{"post", "put"},
 				Headers: []*models.HeaderCacheKeyConfig{
 					{
-						"x-device-id",
+						Key: "x-device-id",
 					},
 				},
 			},
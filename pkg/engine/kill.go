@@ -5,6 +5,7 @@ import (
 	"hermyx/pkg/models"
 	"hermyx/pkg/utils/fs"
 	"hermyx/pkg/utils/hash"
+	"hermyx/pkg/utils/system"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -14,47 +15,68 @@ import (
 )
 
 func KillHermyx(configPath string) error {
+	pid, err := readPid(configPath)
+	if err != nil {
+		return err
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find process with PID %d: %w", pid, err)
+	}
+
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to send SIGTERM to process %d: %w", pid, err)
+	}
+
+	return nil
+}
+
+// runningInstance reports the PID of the instance started from the config
+// when its PID file names a live process. Commands that open the cache
+// backends directly must not run beside it, since it owns the disk cache
+// files.
+func runningInstance(configPath string) (int, bool) {
+	pid, err := readPid(configPath)
+	if err != nil {
+		return 0, false
+	}
+	return pid, system.ProcessAlive(pid)
+}
+
+// readPid reads the PID file of the instance started from the config.
+func readPid(configPath string) (int, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return 0, fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	var config models.HermyxConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+		return 0, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
 	if config.Storage == nil || config.Storage.Path == "" {
 		storageRoot, err := fs.GetUserAppDataDir("hermyx")
 		if err != nil {
-			return fmt.Errorf("failed to determine app data dir: %w", err)
+			return 0, fmt.Errorf("failed to determine app data dir: %w", err)
 		}
 		absConfigPath, err := filepath.Abs(configPath)
 		if err != nil {
-			return fmt.Errorf("failed to resolve absolute config path: %w", err)
+			return 0, fmt.Errorf("failed to resolve absolute config path: %w", err)
 		}
-		config.Storage = &models.StorageConfig{filepath.Join(storageRoot, hash.HashString(absConfigPath))}
+		config.Storage = &models.StorageConfig{Path: filepath.Join(storageRoot, hash.HashString(absConfigPath))}
 	}
 
 	pidPath := filepath.Join(config.Storage.Path, "hermyx.pid")
 	pidData, err := os.ReadFile(pidPath)
 	if err != nil {
-		return fmt.Errorf("failed to read PID file: %w", err)
+		return 0, fmt.Errorf("failed to read PID file: %w", err)
 	}
 
 	pid, err := strconv.Atoi(string(pidData))
 	if err != nil {
-		return fmt.Errorf("invalid PID content in %s: %w", pidPath, err)
+		return 0, fmt.Errorf("invalid PID content in %s: %w", pidPath, err)
 	}
-
-	proc, err := os.FindProcess(pid)
-	if err != nil {
-		return fmt.Errorf("failed to find process with PID %d: %w", pid, err)
-	}
-
-	if err := proc.Signal(syscall.SIGTERM); err != nil {
-		return fmt.Errorf("failed to send SIGTERM to process %d: %w", pid, err)
-	}
-
-	return nil
+	return pid, nil
 }
@@ -0,0 +1,283 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type WarmOptions struct {
+	// File lists one request per line: a URL, or a method and a URL,
+	// optionally followed by "Name: value" headers separated by ";".
+	File string
+
+	// Via is the base URL of a running instance to send the requests
+	// through. When empty, the requests are served by an engine built from
+	// the config, which fetches them through its own route matching and
+	// HostClients and stores the responses in the configured backends.
+	Via string
+
+	Concurrency int
+
+	// Rate caps the requests sent per second; 0 means no limit.
+	Rate float64
+
+	// Progress receives a progress line every second and a summary.
+	Progress io.Writer
+}
+
+type WarmReport struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+type warmRequest struct {
+	method  string
+	uri     string
+	headers [][2]string
+}
+
+func WarmCache(configPath string, options WarmOptions) (WarmReport, error) {
+	requests, err := readWarmRequests(options.File)
+	if err != nil {
+		return WarmReport{}, err
+	}
+
+	var send func(warmRequest) (bool, error)
+	if options.Via != "" {
+		send, err = viaSender(options.Via)
+		if err != nil {
+			return WarmReport{}, err
+		}
+	} else {
+		if pid, running := runningInstance(configPath); running {
+			return WarmReport{}, fmt.Errorf("hermyx is running with PID %d and owns the cache; warm it with --via", pid)
+		}
+		engine := InstantiateHermyxEngine(configPath)
+		defer engine.logger.Close()
+		defer engine.cacheManager.Close()
+		defer engine.refreshes.Wait()
+		if names := engine.memoryBackends(); len(names) > 0 {
+			return WarmReport{}, fmt.Errorf("the memory cache backends %s only live inside the running instance; warm them with --via", strings.Join(names, ", "))
+		}
+		send = engine.directSender()
+	}
+
+	return runWarm(requests, send, options), nil
+}
+
+func readWarmRequests(path string) ([]warmRequest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open warm file: %w", err)
+	}
+	defer file.Close()
+
+	var requests []warmRequest
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		request, err := parseWarmLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		requests = append(requests, request)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read warm file: %w", err)
+	}
+
+	return requests, nil
+}
+
+// parseWarmLine reads "[METHOD] URL [Name: value; Name: value]".
+func parseWarmLine(line string) (warmRequest, error) {
+	request := warmRequest{method: fasthttp.MethodGet}
+
+	first, rest, _ := strings.Cut(line, " ")
+	if first == strings.ToUpper(first) && !strings.ContainsAny(first, "/:") {
+		request.method = first
+		first, rest, _ = strings.Cut(strings.TrimSpace(rest), " ")
+	}
+	if first == "" {
+		return request, errors.New("missing URL")
+	}
+	request.uri = first
+
+	for _, header := range strings.Split(rest, ";") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return request, fmt.Errorf("invalid header %q", strings.TrimSpace(header))
+		}
+		request.headers = append(request.headers, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+
+	return request, nil
+}
+
+func (request warmRequest) writeTo(req *fasthttp.Request) {
+	req.Header.SetMethod(request.method)
+	req.SetRequestURI(request.uri)
+	for _, header := range request.headers {
+		req.Header.Set(header[0], header[1])
+	}
+}
+
+// viaSender sends each request to a running instance. Absolute URLs keep
+// their host as the Host header so that they match the same routes.
+func viaSender(via string) (func(warmRequest) (bool, error), error) {
+	var base fasthttp.URI
+	if err := base.Parse(nil, []byte(via)); err != nil || len(base.Host()) == 0 {
+		return nil, fmt.Errorf("invalid --via address %q", via)
+	}
+
+	client := &fasthttp.Client{}
+	return func(request warmRequest) (bool, error) {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		request.writeTo(req)
+		host := append([]byte(nil), req.Host()...)
+		req.URI().SetSchemeBytes(base.Scheme())
+		req.URI().SetHostBytes(base.Host())
+		req.UseHostHeader = len(host) > 0
+		if req.UseHostHeader {
+			req.Header.SetHostBytes(host)
+		}
+
+		if err := client.Do(req, resp); err != nil {
+			return false, err
+		}
+		return warmSucceeded(resp.StatusCode()), nil
+	}, nil
+}
+
+// directSender serves each request with the engine's own handler. Requests
+// that match no route are skipped rather than proxied raw.
+func (engine *HermyxEngine) directSender() func(warmRequest) (bool, error) {
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	return func(request warmRequest) (bool, error) {
+		var req fasthttp.Request
+		request.writeTo(&req)
+
+		cr, matched := engine.matchRoute(string(req.URI().Path()), strings.ToLower(request.method))
+		if !matched {
+			return false, errWarmSkipped
+		}
+		// A relative URL reaches the upstream as if sent to it directly.
+		if len(req.Host()) == 0 {
+			req.URI().SetHost(targetAddr(cr.Route.Target))
+		}
+
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, remoteAddr, nil)
+		engine.handleRequest(&ctx)
+		// A body too large to cache is left as an upstream stream that no
+		// client reads; closing it gives the connection back.
+		ctx.Response.CloseBodyStream()
+
+		return warmSucceeded(ctx.Response.StatusCode()), nil
+	}
+}
+
+var errWarmSkipped = errors.New("no route matches")
+
+func warmSucceeded(status int) bool {
+	return status >= 200 && status < 400
+}
+
+func runWarm(requests []warmRequest, send func(warmRequest) (bool, error), options WarmOptions) WarmReport {
+	concurrency := max(options.Concurrency, 1)
+
+	var throttle <-chan time.Time
+	if options.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / options.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	var done, failed, skipped atomic.Int64
+	progress := func() {
+		if options.Progress != nil {
+			fmt.Fprintf(options.Progress, "warmed %d/%d (%d failed, %d skipped)\n", done.Load(), len(requests), failed.Load(), skipped.Load())
+		}
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	queue := make(chan warmRequest)
+	var workers sync.WaitGroup
+	for range concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for request := range queue {
+				ok, err := send(request)
+				switch {
+				case errors.Is(err, errWarmSkipped):
+					skipped.Add(1)
+				case err != nil:
+					failed.Add(1)
+					if options.Progress != nil {
+						fmt.Fprintf(options.Progress, "%s %s failed: %v\n", request.method, request.uri, err)
+					}
+				case !ok:
+					failed.Add(1)
+				}
+				done.Add(1)
+			}
+		}()
+	}
+
+	for _, request := range requests {
+		if throttle != nil {
+			<-throttle
+		}
+		queue <- request
+	}
+	close(queue)
+	workers.Wait()
+	close(stop)
+	progress()
+
+	report := WarmReport{
+		Total:   len(requests),
+		Failed:  int(failed.Load()),
+		Skipped: int(skipped.Load()),
+	}
+	report.Succeeded = report.Total - report.Failed - report.Skipped
+	return report
+}
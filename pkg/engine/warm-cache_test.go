@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// diskConfig is testConfig on a disk cache, with a target that carries its
+// scheme.
+var diskConfig = strings.NewReplacer("type: memory", "type: disk", "target: UPSTREAM", "target: http://UPSTREAM").Replace(testConfig)
+
+func TestParseWarmLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    warmRequest
+		wantErr bool
+	}{
+		{line: "/products", want: warmRequest{method: "GET", uri: "/products"}},
+		{line: "http://shop.example/products?page=2", want: warmRequest{method: "GET", uri: "http://shop.example/products?page=2"}},
+		{line: "HEAD /products", want: warmRequest{method: "HEAD", uri: "/products"}},
+		{
+			line: "GET /products Accept-Language: de; X-Tenant: a:b",
+			want: warmRequest{method: "GET", uri: "/products", headers: [][2]string{{"Accept-Language", "de"}, {"X-Tenant", "a:b"}}},
+		},
+		{line: "GET", wantErr: true},
+		{line: "/products nocolon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseWarmLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeWarmFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWarmCacheDirectly(t *testing.T) {
+	var fetches atomic.Int32
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.Host != upstream.Listener.Addr().String() {
+			http.Error(w, "bad host "+r.Host, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	configPath := writeTestConfig(t, diskConfig, upstream)
+	file := writeWarmFile(t, "# warm these", "/a", "", "/b?x=1")
+
+	report, err := WarmCache(configPath, WarmOptions{File: file, Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (WarmReport{Total: 2, Succeeded: 2}) {
+		t.Fatalf("report %+v, want both requests warmed", report)
+	}
+
+	engine := InstantiateHermyxEngine(configPath)
+	defer engine.cacheManager.Close()
+	for _, uri := range []string{"/a", "/b?x=1"} {
+		var req fasthttp.Request
+		req.SetRequestURI(uri)
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		engine.handleRequest(&ctx)
+		if got := string(ctx.Response.Header.Peek("X-Hermyx-Cache")); got != "HIT" {
+			t.Errorf("GET %s after warming: X-Hermyx-Cache %q, want HIT", uri, got)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("upstream fetched %d times, want 2", fetches.Load())
+	}
+}
+
+func TestWarmingLargeBodiesFreesUpstreamConnections(t *testing.T) {
+	var served atomic.Int32
+	large := strings.Repeat("x", 8<<20)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer served.Add(1)
+		w.Write([]byte(large))
+	}))
+	defer upstream.Close()
+
+	config := strings.Replace(diskConfig, "ttl: 1m", "ttl: 1m\n  maxContentSize: 16", 1)
+	configPath := writeTestConfig(t, config, upstream)
+	file := writeWarmFile(t, "/a", "/b")
+
+	if _, err := WarmCache(configPath, WarmOptions{File: file}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An upstream body nobody reads keeps its handler blocked until the
+	// connection is closed.
+	deadline := time.Now().Add(2 * time.Second)
+	for served.Load() < 2 {
+		if time.Now().After(deadline) {
+			finished := served.Load()
+			upstream.CloseClientConnections()
+			t.Fatalf("%d of 2 upstream responses finished; the rest are still held open", finished)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDirectModeRefuses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name    string
+		config  string
+		running bool
+		wantErr string
+	}{
+		{name: "running instance", config: diskConfig, running: true, wantErr: "is running with PID"},
+		{name: "global memory cache", config: testConfig, wantErr: "memory"},
+		{name: "route memory cache", config: strings.Replace(diskConfig, "cache: {enabled: true}", "cache: {enabled: true, type: memory}", 1), wantErr: "memory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := writeTestConfig(t, tt.config, upstream)
+			if tt.running {
+				pidFile := filepath.Join(filepath.Dir(configPath), "hermyx.pid")
+				if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, warmErr := WarmCache(configPath, WarmOptions{File: writeWarmFile(t, "/a")})
+			_, exportErr := ExportCache(configPath, SnapshotOptions{File: filepath.Join(t.TempDir(), "snapshot")})
+			_, importErr := ImportCache(configPath, SnapshotOptions{File: writeWarmFile(t)})
+			for command, err := range map[string]error{"warm": warmErr, "export": exportErr, "import": importErr} {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("%s: err = %v, want one mentioning %q", command, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectModeIgnoresStalePidFile(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	configPath := writeTestConfig(t, diskConfig, upstream)
+	pidFile := filepath.Join(filepath.Dir(configPath), "hermyx.pid")
+	// PIDs are capped well below this on every supported platform.
+	if err := os.WriteFile(pidFile, []byte("999999999"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ExportCache(configPath, SnapshotOptions{File: filepath.Join(t.TempDir(), "snapshot")}); err != nil {
+		t.Errorf("export beside a stale PID file: %v", err)
+	}
+}
@@ -7,12 +7,18 @@ const (
 	CACHE_KEY_METHOD = "method"
 	CACHE_KEY_QUERY  = "query"
 	CACHE_KEY_HEADER = "header"
+
+	CACHE_KEY_ENCODING = "encoding"
+	CACHE_KEY_COOKIE   = "cookie"
+	CACHE_KEY_PARAM    = "param"
+	CACHE_KEY_BODY     = "body"
 )
 
 const (
 	CACHE_TYPE_MEMORY = "memory"
 	CACHE_TYPE_DISK   = "disk"
 	CACHE_TYPE_REDIS  = "redis"
+	CACHE_TYPE_TIERED = "tiered"
 )
 
 type LogConfig struct {
@@ -28,10 +34,25 @@ type HeaderCacheKeyConfig struct {
 	Key string `yaml:"key"`
 }
 
+type QueryKeyConfig struct {
+	Sort            bool     `yaml:"sort"`
+	Include         []string `yaml:"include"`
+	Exclude         []string `yaml:"exclude"`
+	LowercaseValues bool     `yaml:"lowercaseValues"`
+	DropEmpty       bool     `yaml:"dropEmpty"`
+}
+
 type CacheKeyConfig struct {
 	Type           []string                `yaml:"type"`
 	ExcludeMethods []string                `yaml:"excludeMethods"`
 	Headers        []*HeaderCacheKeyConfig `yaml:"headers"`
+	Cookies        []string                `yaml:"cookies"`
+	Params         []string                `yaml:"params"`
+	MaxBodySize    uint64                  `yaml:"maxBodySize"`
+	Query          *QueryKeyConfig         `yaml:"query"`
+	Hash           string                  `yaml:"hash"`
+	Generation     uint64                  `yaml:"generation"`
+	DebugHeader    bool                    `yaml:"debugHeader"`
 }
 
 type RedisConfig struct {
@@ -42,14 +63,49 @@ type RedisConfig struct {
 	KeyNamespace string        `yaml:"namespace"`
 }
 
+type TieredConfig struct {
+	L2         string        `yaml:"l2"`
+	L1Ttl      time.Duration `yaml:"l1Ttl"`
+	L1Capacity uint64        `yaml:"l1Capacity"`
+	L1MaxBytes uint64        `yaml:"l1MaxBytes"`
+}
+
 type CacheConfig struct {
-	Type           string          `yaml:"type"`
-	Enabled        bool            `yaml:"enabled"`
-	Ttl            time.Duration   `yaml:"ttl"`
-	Capacity       uint64          `yaml:"capacity"`
-	KeyConfig      *CacheKeyConfig `yaml:"keyConfig"`
-	MaxContentSize uint64          `yaml:"maxContentSize"`
-	Redis          *RedisConfig    `yaml:"redis"`
+	Type                 string          `yaml:"type"`
+	Enabled              bool            `yaml:"enabled"`
+	Ttl                  time.Duration   `yaml:"ttl"`
+	Capacity             uint64          `yaml:"capacity"`
+	MaxBytes             uint64          `yaml:"maxBytes"`
+	KeyConfig            *CacheKeyConfig `yaml:"keyConfig"`
+	MaxContentSize       uint64          `yaml:"maxContentSize"`
+	Redis                *RedisConfig    `yaml:"redis"`
+	Tiered               *TieredConfig   `yaml:"tiered"`
+	RespectOriginHeaders *bool           `yaml:"respectOriginHeaders"`
+	GenerateEtag         *bool           `yaml:"generateEtag"`
+	GracePeriod          *time.Duration  `yaml:"gracePeriod"`
+	StaleWhileRevalidate *time.Duration  `yaml:"staleWhileRevalidate"`
+	StaleIfError         *time.Duration  `yaml:"staleIfError"`
+	CoalesceTimeout      time.Duration   `yaml:"coalesceTimeout"`
+	DisableCoalescing    *bool           `yaml:"disableCoalescing"`
+	SurrogateKeyHeader   string          `yaml:"surrogateKeyHeader"`
+	InvalidateOnUnsafe   *bool           `yaml:"invalidateOnUnsafe"`
+	Compression          string          `yaml:"compression"`
+	CompressionMinSize   uint64          `yaml:"compressionMinSize"`
+	EdgeCompression      *bool           `yaml:"edgeCompression"`
+	StatusTtl            StatusTtl       `yaml:"statusTtl"`
+	TtlJitter            *float64        `yaml:"ttlJitter"`
+	EarlyRefresh         *float64        `yaml:"earlyRefresh"`
+}
+
+// Setting returns the value of an optional setting, or its zero value when
+// the setting was left out. Optional settings are pointers so that a route
+// can tell "not set, inherit the global value" from "set to off or 0".
+func Setting[T any](value *T) T {
+	var zero T
+	if value == nil {
+		return zero
+	}
+	return *value
 }
 
 type ServerConfig struct {
@@ -60,6 +116,16 @@ type StorageConfig struct {
 	Path string `yaml:"path"`
 }
 
+type AdminConfig struct {
+	Host  string `yaml:"host"`
+	Port  uint16 `yaml:"port"`
+	Token string `yaml:"token"`
+}
+
+type PurgeConfig struct {
+	AllowedCidrs []string `yaml:"allowedCidrs"`
+}
+
 type RouteConfig struct {
 	Name    string       `yaml:"name"`
 	Path    string       `yaml:"path"`
@@ -74,5 +140,7 @@ type HermyxConfig struct {
 	Server  *ServerConfig  `yaml:"server"`
 	Cache   *CacheConfig   `yaml:"cache"`
 	Storage *StorageConfig `yaml:"storage"`
+	Admin   *AdminConfig   `yaml:"admin"`
+	Purge   *PurgeConfig   `yaml:"purge"`
 	Routes  []RouteConfig  `yaml:"routes"`
 }
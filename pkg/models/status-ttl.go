@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StatusTtl maps status codes ("404") and status classes ("5xx") to the
+// time responses with that status stay fresh. A zero TTL keeps them out of
+// the cache.
+type StatusTtl map[string]time.Duration
+
+// UnmarshalYAML accepts Go durations as well as bare seconds and whole days,
+// so that `404: 30s`, `5xx: 0` and `410: 1d` all read as expected.
+func (s *StatusTtl) UnmarshalYAML(node *yaml.Node) error {
+	var raw map[string]string
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	parsed := make(StatusTtl, len(raw))
+	for status, value := range raw {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if !validStatusKey(status) {
+			return fmt.Errorf("statusTtl: %q is neither a status code nor a class like 4xx", status)
+		}
+
+		ttl, err := parseTtl(strings.TrimSpace(value))
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("statusTtl: invalid TTL %q for %s", value, status)
+		}
+		parsed[status] = ttl
+	}
+
+	*s = parsed
+	return nil
+}
+
+func validStatusKey(status string) bool {
+	if len(status) != 3 || status[0] < '1' || status[0] > '5' {
+		return false
+	}
+	if status[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(status)
+	return err == nil
+}
+
+func parseTtl(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.ParseInt(days, 10, 64)
+		return time.Duration(count) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(value)
+}
+
+// Lookup returns the TTL configured for the status, preferring the exact
+// code over its class.
+func (s StatusTtl) Lookup(status int) (time.Duration, bool) {
+	if ttl, ok := s[strconv.Itoa(status)]; ok {
+		return ttl, true
+	}
+	ttl, ok := s[fmt.Sprintf("%dxx", status/100)]
+	return ttl, ok
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestStatusTtlUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    StatusTtl
+		wantErr string
+	}{
+		{name: "duration", yaml: "404: 30s", want: StatusTtl{"404": 30 * time.Second}},
+		{name: "bare seconds", yaml: "301: 3600", want: StatusTtl{"301": time.Hour}},
+		{name: "days", yaml: "410: 1d", want: StatusTtl{"410": 24 * time.Hour}},
+		{name: "zero", yaml: "5xx: 0", want: StatusTtl{"5xx": 0}},
+		{name: "class is lowercased", yaml: "4XX: 1m", want: StatusTtl{"4xx": time.Minute}},
+		{name: "several", yaml: "{200: 10m, 3xx: 1h}", want: StatusTtl{"200": 10 * time.Minute, "3xx": time.Hour}},
+		{name: "status out of range", yaml: "600: 1m", wantErr: "neither a status code"},
+		{name: "short status", yaml: "40: 1m", wantErr: "neither a status code"},
+		{name: "not a status", yaml: "abc: 1m", wantErr: "neither a status code"},
+		{name: "partial class", yaml: "4x1: 1m", wantErr: "neither a status code"},
+		{name: "negative", yaml: "404: -1s", wantErr: "invalid TTL"},
+		{name: "negative days", yaml: "404: -1d", wantErr: "invalid TTL"},
+		{name: "fractional days", yaml: "404: 1.5d", wantErr: "invalid TTL"},
+		{name: "garbage", yaml: "404: soon", wantErr: "invalid TTL"},
+		{name: "not a map", yaml: "[404]", wantErr: "cannot unmarshal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got StatusTtl
+			err := yaml.Unmarshal([]byte(tt.yaml), &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusTtlLookup(t *testing.T) {
+	statusTtl := StatusTtl{"404": 30 * time.Second, "4xx": time.Minute, "5xx": 0}
+
+	tests := []struct {
+		status int
+		want   time.Duration
+		ok     bool
+	}{
+		{status: 404, want: 30 * time.Second, ok: true},
+		{status: 410, want: time.Minute, ok: true},
+		{status: 503, want: 0, ok: true},
+		{status: 301, ok: false},
+		{status: 200, ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := statusTtl.Lookup(tt.status)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%d) = %s, %v, want %s, %v", tt.status, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, ok := StatusTtl(nil).Lookup(200); ok {
+		t.Error("a nil map found a TTL")
+	}
+}
@@ -0,0 +1,137 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings are named by their Content-Encoding tokens so that a stored
+// body can be served as-is to a client that accepts the same token.
+const (
+	ZSTD   = "zstd"
+	GZIP   = "gzip"
+	BROTLI = "br"
+	NONE   = "none"
+
+	IDENTITY = "identity"
+)
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and
+// DecodeAll calls, so a single pair is shared.
+var zstdCodec = sync.OnceValues(func() (*zstd.Encoder, *zstd.Decoder) {
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return encoder, decoder
+})
+
+func Supported(encoding string) bool {
+	switch encoding {
+	case ZSTD, GZIP, BROTLI:
+		return true
+	}
+	return false
+}
+
+func Encode(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case ZSTD:
+		encoder, _ := zstdCodec()
+		return encoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	case GZIP:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case BROTLI:
+		var buf bytes.Buffer
+		writer := brotli.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+func Decode(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case ZSTD:
+		_, decoder := zstdCodec()
+		return decoder.DecodeAll(data, nil)
+	case GZIP:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case BROTLI:
+		return io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// Negotiate reduces an Accept-Encoding header value to the variant a client
+// is served: br or gzip, whichever it rates higher with br winning a tie,
+// or identity when it accepts neither.
+func Negotiate(acceptEncoding []byte) string {
+	best, bestQuality := IDENTITY, 0.0
+	for _, encoding := range []string{BROTLI, GZIP} {
+		if q := acceptedQuality(acceptEncoding, encoding); q > bestQuality {
+			best, bestQuality = encoding, q
+		}
+	}
+	return best
+}
+
+// Accepts reports whether an Accept-Encoding header value allows the given
+// encoding, either by name or through "*", with a non-zero quality.
+func Accepts(acceptEncoding []byte, encoding string) bool {
+	return acceptedQuality(acceptEncoding, encoding) > 0
+}
+
+func acceptedQuality(acceptEncoding []byte, encoding string) float64 {
+	wildcard := 0.0
+	for _, item := range strings.Split(string(acceptEncoding), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case encoding:
+			return quality(params)
+		case "*":
+			wildcard = quality(params)
+		}
+	}
+	return wildcard
+}
+
+func quality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
@@ -0,0 +1,102 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":      {},
+		"short":      []byte("hello"),
+		"repetitive": bytes.Repeat([]byte(`{"id":1,"name":"hermyx"},`), 1000),
+	}
+
+	for _, encoding := range []string{ZSTD, GZIP, BROTLI} {
+		for name, input := range inputs {
+			t.Run(encoding+"/"+name, func(t *testing.T) {
+				encoded, err := Encode(encoding, input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := Decode(encoding, encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decoded, input) {
+					t.Errorf("decoded %d bytes, want the %d encoded", len(decoded), len(input))
+				}
+			})
+		}
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	for _, encoding := range []string{NONE, IDENTITY, "deflate", ""} {
+		if Supported(encoding) {
+			t.Errorf("Supported(%q) = true", encoding)
+		}
+		if _, err := Encode(encoding, []byte("x")); err == nil {
+			t.Errorf("Encode(%q) succeeded", encoding)
+		}
+		if _, err := Decode(encoding, []byte("x")); err == nil {
+			t.Errorf("Decode(%q) succeeded", encoding)
+		}
+	}
+}
+
+func TestDecodeRejectsCorruptData(t *testing.T) {
+	for _, encoding := range []string{ZSTD, GZIP, BROTLI} {
+		if _, err := Decode(encoding, []byte("definitely not compressed")); err == nil {
+			t.Errorf("Decode(%s) accepted corrupt data", encoding)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: IDENTITY},
+		{acceptEncoding: "gzip", want: GZIP},
+		{acceptEncoding: "gzip, deflate, br", want: BROTLI},
+		{acceptEncoding: "br;q=0.5, gzip", want: GZIP},
+		{acceptEncoding: "br;q=0.8, gzip;q=0.8", want: BROTLI},
+		{acceptEncoding: "GZIP", want: GZIP},
+		{acceptEncoding: "*", want: BROTLI},
+		{acceptEncoding: "br;q=0, *", want: GZIP},
+		{acceptEncoding: "gzip;q=0", want: IDENTITY},
+		{acceptEncoding: "deflate, zstd", want: IDENTITY},
+		{acceptEncoding: "gzip;q=invalid", want: IDENTITY},
+		{acceptEncoding: "gzip ; q=0.1", want: GZIP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := Negotiate([]byte(tt.acceptEncoding)); got != tt.want {
+				t.Errorf("Negotiate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		want           bool
+	}{
+		{acceptEncoding: "zstd", encoding: ZSTD, want: true},
+		{acceptEncoding: "gzip, br", encoding: ZSTD},
+		{acceptEncoding: "*", encoding: ZSTD, want: true},
+		{acceptEncoding: "zstd;q=0, *", encoding: ZSTD},
+		{acceptEncoding: "", encoding: GZIP},
+	}
+
+	for _, tt := range tests {
+		if got := Accepts([]byte(tt.acceptEncoding), tt.encoding); got != tt.want {
+			t.Errorf("Accepts(%q, %s) = %v, want %v", tt.acceptEncoding, tt.encoding, got, tt.want)
+		}
+	}
+}
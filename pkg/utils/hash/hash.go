@@ -11,3 +11,9 @@ func HashString(str string) string {
 
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
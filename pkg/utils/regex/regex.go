@@ -9,3 +9,60 @@ func CombinePattenrs(patterns []string) *regexp.Regexp {
 	combined := "(?:" + strings.Join(patterns, ")|(?:") + ")"
 	return regexp.MustCompile(combined)
 }
+
+// FromGlob compiles a Redis-style glob into an anchored regexp: `*` matches
+// any run of characters (including `/`), `?` any single character, `[...]`
+// a character class, and `\` escapes the next character.
+func FromGlob(glob string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			pattern.WriteString("(?s:.*)")
+		case '?':
+			pattern.WriteString("(?s:.)")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				pattern.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + strings.ReplaceAll(class[1:], `\`, `\\`)
+			} else {
+				class = strings.ReplaceAll(class, `\`, `\\`)
+			}
+			pattern.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			// Copy bytes rather than runes so that multi-byte characters
+			// come through intact.
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	pattern.WriteString("$")
+	return regexp.Compile(pattern.String())
+}
+
+// EscapeGlob quotes every glob metacharacter in s so that it only matches
+// itself.
+func EscapeGlob(s string) string {
+	var escaped strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,58 @@
+package regex
+
+import "testing"
+
+func TestFromGlob(t *testing.T) {
+	tests := []struct {
+		glob    string
+		matches []string
+		misses  []string
+	}{
+		{glob: "api|GET|/users", matches: []string{"api|GET|/users"}, misses: []string{"api|GET|/users/1", "xapi|GET|/users"}},
+		{glob: "api|*", matches: []string{"api|", "api|GET|/a/b/c", "api|\n"}, misses: []string{"api", "web|GET|/"}},
+		{glob: "*users*", matches: []string{"api|GET|/users?page=2"}, misses: []string{"api|GET|/items"}},
+		{glob: "a?c", matches: []string{"abc", "a/c"}, misses: []string{"ac", "abbc"}},
+		{glob: "v[12]", matches: []string{"v1", "v2"}, misses: []string{"v3", "v12"}},
+		{glob: "v[^12]", matches: []string{"v3"}, misses: []string{"v1"}},
+		{glob: "v[0-9]", matches: []string{"v7"}, misses: []string{"va"}},
+		{glob: `a\*b`, matches: []string{"a*b"}, misses: []string{"axb"}},
+		{glob: `a\?`, matches: []string{"a?"}, misses: []string{"ab"}},
+		{glob: "a[b", matches: []string{"a[b"}, misses: []string{"ab"}},
+		{glob: "/path.(x)+", matches: []string{"/path.(x)+"}, misses: []string{"/pathX(x)"}},
+		{glob: `trailing\`, matches: []string{`trailing\`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			matcher, err := FromGlob(tt.glob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.matches {
+				if !matcher.MatchString(s) {
+					t.Errorf("%q does not match %q", tt.glob, s)
+				}
+			}
+			for _, s := range tt.misses {
+				if matcher.MatchString(s) {
+					t.Errorf("%q matches %q", tt.glob, s)
+				}
+			}
+		})
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	for _, s := range []string{"plain", "api|GET|/a*b", "q?x=[1]", `back\slash`, "ü*"} {
+		matcher, err := FromGlob(EscapeGlob(s))
+		if err != nil {
+			t.Fatalf("EscapeGlob(%q): %v", s, err)
+		}
+		if !matcher.MatchString(s) {
+			t.Errorf("escaped %q does not match itself", s)
+		}
+		if matcher.MatchString(s + "x") {
+			t.Errorf("escaped %q matches more than itself", s)
+		}
+	}
+}
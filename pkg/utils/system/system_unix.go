@@ -0,0 +1,14 @@
+//go:build darwin || linux
+
+package system
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ProcessAlive reports whether a process with the PID exists.
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package system
+
+import "os"
+
+// ProcessAlive reports whether a process with the PID exists. On Windows
+// FindProcess opens the process, which fails once it is gone.
+func ProcessAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	proc.Release()
+	return true
+}